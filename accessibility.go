@@ -0,0 +1,263 @@
+package geomi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The names of the accessibility checks, used as the Rule of their Issues.
+const (
+	A11yImageAlt     = "image-alt"     // images without alt text
+	A11yFormLabel    = "form-label"    // form fields without a label
+	A11yEmptyLink    = "empty-link"    // links without text
+	A11yEmptyButton  = "empty-button"  // buttons without text
+	A11yHeadingOrder = "heading-order" // heading levels that are skipped, e.g. an h4 after an h2
+	A11yHTMLLang     = "html-lang"     // pages without a lang
+	A11yDuplicateID  = "duplicate-id"  // ids that are used more than once
+	A11yLinkText     = "link-text"     // links whose text doesn't say where they go, e.g. "click here"
+)
+
+// genericLinkText is link text that doesn't describe the link's destination.
+var genericLinkText = map[string]bool{
+	"click":          true,
+	"click for more": true,
+	"click here":     true,
+	"continue":       true,
+	"continue here":  true,
+	"details":        true,
+	"find out more":  true,
+	"go":             true,
+	"here":           true,
+	"learn more":     true,
+	"link":           true,
+	"more":           true,
+	"more details":   true,
+	"more info":      true,
+	"read more":      true,
+	"this":           true,
+	"this page":      true,
+}
+
+// unlabeledInputs are the input types that don't need a label.
+var unlabeledInputs = map[string]bool{
+	"hidden": true,
+	"submit": true,
+	"reset":  true,
+	"button": true,
+	"image":  true,
+}
+
+// checkAccessibility returns the accessibility issues in the html document.
+func checkAccessibility(doc *html.Node) []Issue {
+	a := &a11y{ids: make(map[string]int), labels: make(map[string]bool)}
+	// labels can come after their fields, find them first
+	walk(doc, func(n *html.Node) {
+		if id := attr(n, "id"); id != "" {
+			a.ids[id]++
+		}
+		if n.DataAtom == atom.Label {
+			if f := attr(n, "for"); f != "" {
+				a.labels[f] = true
+			}
+		}
+	})
+	walk(doc, a.check)
+	var dups []string
+	for id, n := range a.ids {
+		if n > 1 {
+			dups = append(dups, id)
+		}
+	}
+	sort.Strings(dups)
+	for _, id := range dups {
+		a.add(A11yDuplicateID, SeverityWarning, "id %q is used %d times", id, a.ids[id])
+	}
+	sort.SliceStable(a.issues, func(i, j int) bool {
+		return a.issues[i].Severity > a.issues[j].Severity
+	})
+	return a.issues
+}
+
+// a11y holds the state of an accessibility check.
+type a11y struct {
+	ids     map[string]int  // the number of times each id is used
+	labels  map[string]bool // the ids that have a label
+	heading int             // the level of the last heading
+	issues  []Issue
+}
+
+func (a *a11y) add(rule string, sev Severity, format string, args ...interface{}) {
+	a.issues = append(a.issues, Issue{rule, sev, fmt.Sprintf(format, args...)})
+}
+
+// check checks the node.
+func (a *a11y) check(n *html.Node) {
+	if n.Type != html.ElementNode {
+		return
+	}
+	switch n.DataAtom {
+	case atom.Html:
+		if strings.TrimSpace(attr(n, "lang")) == "" {
+			a.add(A11yHTMLLang, SeverityError, "the html element has no lang")
+		}
+	case atom.Img:
+		if _, ok := attrOK(n, "alt"); !ok && !ariaHidden(n) {
+			a.add(A11yImageAlt, SeverityError, "image %s has no alt text", attr(n, "src"))
+		}
+	case atom.Input:
+		typ := strings.ToLower(attr(n, "type"))
+		switch {
+		case typ == "button" && strings.TrimSpace(attr(n, "value")) == "" && !hasName(n):
+			a.add(A11yEmptyButton, SeverityError, "input button %s has no text", describe(n))
+		case typ == "image" && strings.TrimSpace(attr(n, "alt")) == "" && !hasName(n):
+			a.add(A11yEmptyButton, SeverityError, "image button %s has no alt text", describe(n))
+		case !unlabeledInputs[typ] && !a.labeled(n):
+			a.add(A11yFormLabel, SeverityError, "input %s has no label", describe(n))
+		}
+	case atom.Select, atom.Textarea:
+		if !a.labeled(n) {
+			a.add(A11yFormLabel, SeverityError, "%s %s has no label", n.Data, describe(n))
+		}
+	case atom.Button:
+		if accessibleText(n) == "" && !hasName(n) {
+			a.add(A11yEmptyButton, SeverityError, "button %s has no text", describe(n))
+		}
+	case atom.A:
+		href, ok := attrOK(n, "href")
+		if !ok || ariaHidden(n) {
+			return
+		}
+		text := accessibleText(n)
+		switch {
+		case text == "" && !hasName(n):
+			a.add(A11yEmptyLink, SeverityError, "link to %s has no text", href)
+		case genericLinkText[strings.ToLower(strings.Trim(text, ".!?…»›> "))]:
+			a.add(A11yLinkText, SeverityWarning, "link to %s has generic text: %q", href, text)
+		}
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		if a.heading > 0 && level > a.heading+1 {
+			a.add(A11yHeadingOrder, SeverityWarning, "h%d follows h%d, skipping a level", level, a.heading)
+		}
+		a.heading = level
+	}
+}
+
+// labeled returns whether the form field has a label: a label element, either
+// around it or referring to it, or an aria-label, aria-labelledby, or title.
+func (a *a11y) labeled(n *html.Node) bool {
+	if hasName(n) {
+		return true
+	}
+	if id := attr(n, "id"); id != "" && a.labels[id] {
+		return true
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == atom.Label {
+			return true
+		}
+	}
+	return false
+}
+
+// hasName returns whether the element has an accessible name from its
+// attributes.
+func hasName(n *html.Node) bool {
+	return strings.TrimSpace(attr(n, "aria-label")) != "" || strings.TrimSpace(attr(n, "aria-labelledby")) != "" || strings.TrimSpace(attr(n, "title")) != ""
+}
+
+// ariaHidden returns whether the element is hidden from assistive technology.
+func ariaHidden(n *html.Node) bool {
+	role := strings.ToLower(attr(n, "role"))
+	return attr(n, "aria-hidden") == "true" || role == "presentation" || role == "none"
+}
+
+// accessibleText returns the element's text, including the alt text of images,
+// with white space collapsed.
+func accessibleText(n *html.Node) string {
+	var b strings.Builder
+	walk(n, func(c *html.Node) {
+		switch {
+		case c.Type == html.TextNode:
+			b.WriteString(c.Data)
+			b.WriteByte(' ')
+		case c.DataAtom == atom.Img:
+			b.WriteString(attr(c, "alt"))
+			b.WriteByte(' ')
+		}
+	})
+	return collapseSpace(b.String())
+}
+
+// describe returns something that identifies the element in a message.
+func describe(n *html.Node) string {
+	for _, k := range []string{"id", "name"} {
+		if v := attr(n, k); v != "" {
+			return fmt.Sprintf("%s=%q", k, v)
+		}
+	}
+	return "(unnamed)"
+}
+
+// walk calls f for n and each of its descendants, in document order. The
+// contents of script, style, and template elements aren't walked.
+func walk(n *html.Node, f func(*html.Node)) {
+	f(n)
+	if n.DataAtom == atom.Script || n.DataAtom == atom.Style || n.DataAtom == atom.Template {
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, f)
+	}
+}
+
+// attr returns the value of the node's attribute.
+func attr(n *html.Node, key string) string {
+	v, _ := attrOK(n, key)
+	return v
+}
+
+// attrOK returns the value of the node's attribute and whether it has it.
+func attrOK(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// AccessibilityReport is the site wide summary of the accessibility checks.
+type AccessibilityReport struct {
+	Pages           int                // the number of pages checked
+	PagesWithIssues int                // the number of pages with at least one issue
+	Counts          map[string]int     // the number of issues found by each check
+	Issues          map[string][]Issue // the issues by page url; pages without issues aren't included
+}
+
+// AccessibilityReport aggregates the accessibility issues of the crawled pages.
+// Pages are only checked when CheckAccessibility is set.
+func (s *Spider) AccessibilityReport() AccessibilityReport {
+	rep := AccessibilityReport{Counts: make(map[string]int), Issues: make(map[string][]Issue)}
+	s.Lock()
+	defer s.Unlock()
+	for k, p := range s.Pages {
+		if !p.accessibilityChecked {
+			continue
+		}
+		rep.Pages++
+		if len(p.Accessibility) == 0 {
+			continue
+		}
+		rep.PagesWithIssues++
+		rep.Issues[k] = p.Accessibility
+		for _, i := range p.Accessibility {
+			rep.Counts[i.Rule]++
+		}
+	}
+	return rep
+}
@@ -0,0 +1,83 @@
+package geomi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckAccessibility(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		expected []Issue
+	}{
+		{"clean", `<html lang="en"><h1>a</h1><h2>b</h2><h2>c</h2><h3>d</h3><h2>e</h2>
+<img src="a.png" alt="A"><img src="spacer.gif" alt=""><img src="x.png" role="presentation">
+<a href="/doc/">Documentation</a><a href="/"><img src="logo.png" alt="Home"></a><a href="/x" aria-label="Close"></a><a name="top"></a>
+<form><label>Name <input name="name"></label><label for="q">Search</label><input id="q" type="search">
+<input type="hidden" name="t"><input type="submit"><input type="button" value="Go"><textarea title="Comments"></textarea>
+<button>Send</button><button aria-label="Close"><svg></svg></button></form></html>`, nil},
+		{"issues", `<html><h1 id="a">a</h1><h3 id="a">b</h3>
+<img src="a.png"><a href="/empty"></a><a href="/more">Read more…</a><a href="/here"> Click  here </a>
+<form><input name="email"><select id="s"></select><input type="image" src="go.png"><button id="b"></button></form></html>`, []Issue{
+			{A11yHTMLLang, SeverityError, "the html element has no lang"},
+			{A11yImageAlt, SeverityError, "image a.png has no alt text"},
+			{A11yEmptyLink, SeverityError, "link to /empty has no text"},
+			{A11yFormLabel, SeverityError, `input name="email" has no label`},
+			{A11yFormLabel, SeverityError, `select id="s" has no label`},
+			{A11yEmptyButton, SeverityError, "image button (unnamed) has no alt text"},
+			{A11yEmptyButton, SeverityError, `button id="b" has no text`},
+			{A11yHeadingOrder, SeverityWarning, "h3 follows h1, skipping a level"},
+			{A11yLinkText, SeverityWarning, `link to /more has generic text: "Read more…"`},
+			{A11yLinkText, SeverityWarning, `link to /here has generic text: "Click here"`},
+			{A11yDuplicateID, SeverityWarning, `id "a" is used 2 times`},
+		}},
+	}
+	for _, test := range tests {
+		issues := checkAccessibility(parseHTML(t, test.html))
+		if !reflect.DeepEqual(issues, test.expected) {
+			t.Errorf("%s: expected %d issues:", test.name, len(test.expected))
+			for _, i := range test.expected {
+				t.Errorf("\t%v", i)
+			}
+			t.Errorf("got %d:", len(issues))
+			for _, i := range issues {
+				t.Errorf("\t%v", i)
+			}
+		}
+	}
+}
+
+func TestAccessibilityReport(t *testing.T) {
+	html := ResponseInfo{StatusCode: 200, ContentType: "text/html"}
+	f := statusFetcher{
+		"http://golang.org/doc/":  {`<html lang="en"><a href="/doc/a">A</a></html>`, html, []string{"http://golang.org/doc/a", "http://golang.org/doc/b"}},
+		"http://golang.org/doc/a": {`<html><img src="x.png"></html>`, html, nil},
+		"http://golang.org/doc/b": {`<html><img src="x.png"></html>`, ResponseInfo{StatusCode: 200, ContentType: "text/plain"}, nil},
+	}
+	tests := []struct {
+		check    bool
+		expected AccessibilityReport
+	}{
+		// pages aren't checked unless asked to be
+		{false, AccessibilityReport{Counts: map[string]int{}, Issues: map[string][]Issue{}}},
+		// b isn't html, so it isn't checked
+		{true, AccessibilityReport{
+			Pages:           2,
+			PagesWithIssues: 1,
+			Counts:          map[string]int{A11yHTMLLang: 1, A11yImageAlt: 1},
+			Issues: map[string][]Issue{
+				"http://golang.org/doc/a": {
+					{A11yHTMLLang, SeverityError, "the html element has no lang"},
+					{A11yImageAlt, SeverityError, "image x.png has no alt text"},
+				},
+			},
+		}},
+	}
+	for _, test := range tests {
+		s := crawledSpider(t, "http://golang.org/doc/", AdaptFetcher(f), func(c *Config) { c.CheckAccessibility = test.check })
+		if rep := s.AccessibilityReport(); !reflect.DeepEqual(rep, test.expected) {
+			t.Errorf("check %t: expected %+v, got %+v", test.check, test.expected, rep)
+		}
+	}
+}
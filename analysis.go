@@ -0,0 +1,172 @@
+package geomi
+
+import "sort"
+
+// DefaultDamping is the damping factor used by PageRank when one isn't provided.
+const DefaultDamping = 0.85
+
+// internalEdges returns the graph's links between internal nodes as an adjacency
+// list. Links from a page to itself are ignored.
+func (g *Graph) internalEdges() map[string][]string {
+	adj := make(map[string][]string)
+	for _, e := range g.Edges {
+		if e.Kind != LinkInternal || e.From == e.To {
+			continue
+		}
+		adj[e.From] = append(adj[e.From], e.To)
+	}
+	return adj
+}
+
+// InDegree returns the number of distinct internal pages that link to each
+// internal node.
+func (g *Graph) InDegree() map[string]int {
+	in := make(map[string]int)
+	for _, n := range g.Nodes {
+		if n.Kind == LinkInternal {
+			in[n.URL] = 0
+		}
+	}
+	for _, to := range g.internalEdges() {
+		for _, t := range to {
+			in[t]++
+		}
+	}
+	return in
+}
+
+// PageRank returns the PageRank of each internal node using only internal links.
+// If damping isn't between 0 and 1, DefaultDamping is used. Iteration stops when
+// the total change is below 1e-9 or after iterations rounds, whichever happens
+// first. Rank from pages without outgoing internal links is spread evenly across
+// all pages.
+func (g *Graph) PageRank(damping float64, iterations int) map[string]float64 {
+	if damping <= 0 || damping >= 1 {
+		damping = DefaultDamping
+	}
+	var nodes []string
+	for _, n := range g.Nodes {
+		if n.Kind == LinkInternal {
+			nodes = append(nodes, n.URL)
+		}
+	}
+	rank := make(map[string]float64, len(nodes))
+	if len(nodes) == 0 {
+		return rank
+	}
+	N := float64(len(nodes))
+	for _, n := range nodes {
+		rank[n] = 1 / N
+	}
+	adj := g.internalEdges()
+	for i := 0; i < iterations; i++ {
+		var dangling float64
+		for _, n := range nodes {
+			if len(adj[n]) == 0 {
+				dangling += rank[n]
+			}
+		}
+		next := make(map[string]float64, len(nodes))
+		for _, n := range nodes {
+			next[n] = (1-damping)/N + damping*dangling/N
+		}
+		for _, n := range nodes {
+			for _, t := range adj[n] {
+				next[t] += damping * rank[n] / float64(len(adj[n]))
+			}
+		}
+		var delta float64
+		for _, n := range nodes {
+			d := next[n] - rank[n]
+			if d < 0 {
+				d = -d
+			}
+			delta += d
+		}
+		rank = next
+		if delta < 1e-9 {
+			break
+		}
+	}
+	return rank
+}
+
+// ClickDepth returns the minimum number of clicks needed to get from any of the
+// seeds to each reachable internal node, following only internal links. Unlike
+// a page's distance, which is the depth at which the crawl first found it, this
+// is the true shortest path. If no seeds are passed, the graph's seeds are used.
+// Nodes that can't be reached are not in the result.
+func (g *Graph) ClickDepth(seeds ...string) map[string]int {
+	if len(seeds) == 0 {
+		seeds = g.Seeds
+	}
+	adj := g.internalEdges()
+	depth := make(map[string]int)
+	var queue []string
+	for _, s := range seeds {
+		if n, ok := g.Node(s); !ok || n.Kind != LinkInternal {
+			continue
+		}
+		if _, ok := depth[s]; !ok {
+			depth[s] = 0
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, t := range adj[n] {
+			if _, ok := depth[t]; ok {
+				continue
+			}
+			depth[t] = depth[n] + 1
+			queue = append(queue, t)
+		}
+	}
+	return depth
+}
+
+// DeadEnds returns a sorted list of fetched pages that have no outgoing internal
+// links.
+func (g *Graph) DeadEnds() []string {
+	adj := g.internalEdges()
+	var dead []string
+	for _, n := range g.Nodes {
+		if n.Fetched && len(adj[n.URL]) == 0 {
+			dead = append(dead, n.URL)
+		}
+	}
+	return dead
+}
+
+// Orphans returns a sorted list of internal nodes, other than the seeds, that no
+// internal page links to.
+func (g *Graph) Orphans() []string {
+	seeds := make(map[string]struct{}, len(g.Seeds))
+	for _, s := range g.Seeds {
+		seeds[s] = struct{}{}
+	}
+	var orphans []string
+	for u, n := range g.InDegree() {
+		if _, ok := seeds[u]; ok || n > 0 {
+			continue
+		}
+		orphans = append(orphans, u)
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// DeepPages returns a sorted list of internal nodes whose click depth from the
+// seeds is greater than max. These are the pages that can only be reached
+// through long chains of links.
+func (g *Graph) DeepPages(max int) []string {
+	var deep []string
+	for u, d := range g.ClickDepth() {
+		if d > max {
+			deep = append(deep, u)
+		}
+	}
+	sort.Strings(deep)
+	return deep
+}
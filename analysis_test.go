@@ -0,0 +1,83 @@
+package geomi
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// analysisGraph returns a graph shaped like:
+//
+//	a -> b -> c -> d -> e
+//	a -> c
+//	b -> a
+//	e -> x (external)
+//	o (nothing links to o)
+func analysisGraph() *Graph {
+	g := &Graph{Seeds: []string{"a"}}
+	for _, u := range []string{"a", "b", "c", "d", "e", "o"} {
+		g.Nodes = append(g.Nodes, Node{URL: u, Kind: LinkInternal, Fetched: true})
+	}
+	g.Nodes = append(g.Nodes, Node{URL: "x", Kind: LinkExternal})
+	for _, e := range [][2]string{{"a", "b"}, {"a", "c"}, {"b", "a"}, {"b", "c"}, {"c", "d"}, {"d", "e"}, {"d", "d"}} {
+		g.Edges = append(g.Edges, Edge{From: e[0], To: e[1], Kind: LinkInternal, Weight: 1})
+	}
+	g.Edges = append(g.Edges, Edge{From: "e", To: "x", Kind: LinkExternal, Weight: 1})
+	g.reindex()
+	return g
+}
+
+func TestInDegree(t *testing.T) {
+	expected := map[string]int{"a": 1, "b": 1, "c": 2, "d": 1, "e": 1, "o": 0}
+	in := analysisGraph().InDegree()
+	if !reflect.DeepEqual(in, expected) {
+		t.Errorf("Expected %v, got %v", expected, in)
+	}
+}
+
+func TestPageRank(t *testing.T) {
+	rank := analysisGraph().PageRank(0, 100)
+	if len(rank) != 6 {
+		t.Fatalf("Expected 6 ranked pages, got %d", len(rank))
+	}
+	var sum float64
+	for _, r := range rank {
+		sum += r
+	}
+	if math.Abs(sum-1) > 1e-6 {
+		t.Errorf("Expected ranks to sum to 1, got %f", sum)
+	}
+	if rank["c"] <= rank["b"] {
+		t.Errorf("Expected c to outrank b: c=%f b=%f", rank["c"], rank["b"])
+	}
+	if rank["o"] >= rank["a"] {
+		t.Errorf("Expected the orphan to rank below a: o=%f a=%f", rank["o"], rank["a"])
+	}
+}
+
+func TestClickDepth(t *testing.T) {
+	g := analysisGraph()
+	expected := map[string]int{"a": 0, "b": 1, "c": 1, "d": 2, "e": 3}
+	depth := g.ClickDepth()
+	if !reflect.DeepEqual(depth, expected) {
+		t.Errorf("Expected %v, got %v", expected, depth)
+	}
+	expected = map[string]int{"d": 0, "e": 1}
+	depth = g.ClickDepth("d", "x")
+	if !reflect.DeepEqual(depth, expected) {
+		t.Errorf("Expected %v, got %v", expected, depth)
+	}
+}
+
+func TestDeadEndsOrphansDeepPages(t *testing.T) {
+	g := analysisGraph()
+	if dead := g.DeadEnds(); !reflect.DeepEqual(dead, []string{"e", "o"}) {
+		t.Errorf("Expected dead ends to be [e o], got %v", dead)
+	}
+	if orphans := g.Orphans(); !reflect.DeepEqual(orphans, []string{"o"}) {
+		t.Errorf("Expected orphans to be [o], got %v", orphans)
+	}
+	if deep := g.DeepPages(1); !reflect.DeepEqual(deep, []string{"d", "e"}) {
+		t.Errorf("Expected deep pages to be [d e], got %v", deep)
+	}
+}
@@ -0,0 +1,359 @@
+package geomi
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	DefaultMaxTitleLength = 60  // the title length, in characters, above which a title is too long
+	DefaultMinWords       = 300 // the word count below which a page's content is thin
+)
+
+// Severity is how serious an audit issue is.
+type Severity int
+
+const (
+	SeverityNotice Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityNotice:
+		return "notice"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Issue is a problem found by an audit rule.
+type Issue struct {
+	Rule     string // the name of the rule that found it
+	Severity Severity
+	Message  string
+}
+
+// Rule is an audit check. Check is called for each page being audited and
+// returns the page's issues, if any.
+type Rule interface {
+	Name() string
+	Check(c *AuditContext, p Page) []Issue
+}
+
+// ContentRule is a Rule that checks a page's extracted Content; Audit only runs
+// it on the pages that have content, see AuditContext.HasContent. A custom rule
+// is one if it implements ChecksContent, which does nothing.
+type ContentRule interface {
+	Rule
+	ChecksContent()
+}
+
+// AuditContext is what rules have to work with: the crawled pages and the
+// responses for every url that was fetched or checked, internal and external.
+type AuditContext struct {
+	Pages     map[string]Page
+	Responses map[string]ResponseInfo
+	titles    map[string][]string // urls by title, built on first use
+}
+
+// PagesWithTitle returns the urls of the html pages with the title, sorted. Only
+// pages that have content are included, see HasContent.
+func (c *AuditContext) PagesWithTitle(title string) []string {
+	if c.titles == nil {
+		c.titles = make(map[string][]string)
+		for k, p := range c.Pages {
+			if c.IsHTML(k) && c.HasContent(k) {
+				c.titles[p.Title] = append(c.titles[p.Title], k)
+			}
+		}
+		for _, urls := range c.titles {
+			sort.Strings(urls)
+		}
+	}
+	return c.titles[title]
+}
+
+// HasContent returns whether the url's response was a 2xx whose content was
+// extracted. Error pages, and pages crawled without ExtractContent, have none,
+// so what's missing from their Content isn't missing from the page.
+func (c *AuditContext) HasContent(u string) bool {
+	r, ok := c.Responses[u]
+	if !ok || r.Err != nil || r.StatusCode < 200 || r.StatusCode > 299 {
+		return false
+	}
+	return c.Pages[u].contentExtracted
+}
+
+// IsHTML returns whether the url's response was html.
+func (c *AuditContext) IsHTML(u string) bool {
+	return isHTML(mediaType(c.Responses[u].ContentType))
+}
+
+// DefaultRules returns geomi's audit rules, with their default settings.
+func DefaultRules() []Rule {
+	return []Rule{
+		MissingTitleRule{},
+		DuplicateTitleRule{},
+		TitleLengthRule{MaxLength: DefaultMaxTitleLength},
+		MissingDescriptionRule{},
+		MultipleH1Rule{},
+		MissingAltRule{},
+		NonCanonicalLinkRule{},
+		BrokenFragmentRule{},
+		BrokenHreflangRule{},
+		HreflangCodeRule{},
+		HreflangReturnLinkRule{},
+		HreflangXDefaultRule{},
+		ThinContentRule{MinWords: DefaultMinWords},
+	}
+}
+
+// Audit runs the rules against each html page that was crawled; if no rules are
+// passed, the DefaultRules are used. The issues are returned by page url, most
+// severe first. Pages without issues aren't included.
+func (s *Spider) Audit(rules ...Rule) map[string][]Issue {
+	if len(rules) == 0 {
+		rules = DefaultRules()
+	}
+	return s.audit((*AuditContext).IsHTML, rules)
+}
+
+// audit runs the rules against the pages for which include returns true. Rules
+// that check the page's content are skipped for pages that don't have any.
+func (s *Spider) audit(include func(c *AuditContext, u string) bool, rules []Rule) map[string][]Issue {
+	c := &AuditContext{
+		Pages:     make(map[string]Page),
+		Responses: make(map[string]ResponseInfo),
+	}
+	s.Lock()
+	for k, p := range s.Pages {
+		c.Pages[k] = p
+	}
+	for k, r := range s.externalLinks {
+		c.Responses[k] = r
+	}
+	for k, r := range s.fetchedURLs {
+		c.Responses[k] = r
+	}
+	s.Unlock()
+	issues := make(map[string][]Issue)
+	for k, p := range c.Pages {
+		if !include(c, k) {
+			continue
+		}
+		hasContent := c.HasContent(k)
+		var found []Issue
+		for _, r := range rules {
+			if _, ok := r.(ContentRule); ok && !hasContent {
+				continue
+			}
+			found = append(found, r.Check(c, p)...)
+		}
+		if len(found) == 0 {
+			continue
+		}
+		sort.SliceStable(found, func(i, j int) bool {
+			if found[i].Severity != found[j].Severity {
+				return found[i].Severity > found[j].Severity
+			}
+			return found[i].Rule < found[j].Rule
+		})
+		issues[k] = found
+	}
+	return issues
+}
+
+// MissingTitleRule finds pages without a title.
+type MissingTitleRule struct{}
+
+func (MissingTitleRule) Name() string { return "missing-title" }
+
+func (MissingTitleRule) ChecksContent() {}
+
+func (r MissingTitleRule) Check(c *AuditContext, p Page) []Issue {
+	if p.Title != "" {
+		return nil
+	}
+	return []Issue{{r.Name(), SeverityError, "the page has no title"}}
+}
+
+// DuplicateTitleRule finds pages whose title is used by other pages.
+type DuplicateTitleRule struct{}
+
+func (DuplicateTitleRule) Name() string { return "duplicate-title" }
+
+func (DuplicateTitleRule) ChecksContent() {}
+
+func (r DuplicateTitleRule) Check(c *AuditContext, p Page) []Issue {
+	if p.Title == "" {
+		return nil
+	}
+	urls := c.PagesWithTitle(p.Title)
+	if len(urls) < 2 {
+		return nil
+	}
+	var others []string
+	for _, u := range urls {
+		if u != p.URL.String() {
+			others = append(others, u)
+		}
+	}
+	return []Issue{{r.Name(), SeverityWarning, fmt.Sprintf("the title is also used by %s", strings.Join(others, ", "))}}
+}
+
+// TitleLengthRule finds pages whose title is longer than MaxLength characters.
+type TitleLengthRule struct {
+	MaxLength int
+}
+
+func (TitleLengthRule) Name() string { return "title-too-long" }
+
+func (TitleLengthRule) ChecksContent() {}
+
+func (r TitleLengthRule) Check(c *AuditContext, p Page) []Issue {
+	n := utf8.RuneCountInString(p.Title)
+	if n <= r.MaxLength {
+		return nil
+	}
+	return []Issue{{r.Name(), SeverityWarning, fmt.Sprintf("the title is %d characters, the max is %d", n, r.MaxLength)}}
+}
+
+// MissingDescriptionRule finds pages without a meta description.
+type MissingDescriptionRule struct{}
+
+func (MissingDescriptionRule) Name() string { return "missing-description" }
+
+func (MissingDescriptionRule) ChecksContent() {}
+
+func (r MissingDescriptionRule) Check(c *AuditContext, p Page) []Issue {
+	if p.Description != "" {
+		return nil
+	}
+	return []Issue{{r.Name(), SeverityWarning, "the page has no meta description"}}
+}
+
+// MultipleH1Rule finds pages with more than one h1.
+type MultipleH1Rule struct{}
+
+func (MultipleH1Rule) Name() string { return "multiple-h1" }
+
+func (MultipleH1Rule) ChecksContent() {}
+
+func (r MultipleH1Rule) Check(c *AuditContext, p Page) []Issue {
+	var n int
+	for _, h := range p.Headings {
+		if h.Level == 1 {
+			n++
+		}
+	}
+	if n < 2 {
+		return nil
+	}
+	return []Issue{{r.Name(), SeverityWarning, fmt.Sprintf("the page has %d h1 elements", n)}}
+}
+
+// MissingAltRule finds images without an alt attribute. An empty alt is fine, it
+// marks the image as decorative.
+type MissingAltRule struct{}
+
+func (MissingAltRule) Name() string { return "missing-alt" }
+
+func (MissingAltRule) ChecksContent() {}
+
+func (r MissingAltRule) Check(c *AuditContext, p Page) []Issue {
+	var issues []Issue
+	for _, img := range p.Images {
+		if !img.HasAlt {
+			issues = append(issues, Issue{r.Name(), SeverityWarning, fmt.Sprintf("image %s has no alt text", img.Src)})
+		}
+	}
+	return issues
+}
+
+// NonCanonicalLinkRule finds links to internal pages that have a different
+// canonical url, i.e. links that should point to the canonical url instead.
+type NonCanonicalLinkRule struct{}
+
+func (NonCanonicalLinkRule) Name() string { return "non-canonical-link" }
+
+func (r NonCanonicalLinkRule) Check(c *AuditContext, p Page) []Issue {
+	var issues []Issue
+	seen := make(map[string]bool)
+	for _, l := range p.links {
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		target, ok := c.Pages[l]
+		if !ok || target.Canonical == "" || sameURL(l, target.Canonical) {
+			continue
+		}
+		issues = append(issues, Issue{r.Name(), SeverityNotice, fmt.Sprintf("link to %s, whose canonical url is %s", l, target.Canonical)})
+	}
+	return issues
+}
+
+// BrokenHreflangRule finds hreflang alternates that point to urls that didn't
+// return a 200, e.g. redirects and broken urls. An alternate that redirects is
+// found even if the url it redirects to is a 200. Only alternates that were
+// fetched or checked can be found.
+type BrokenHreflangRule struct{}
+
+func (BrokenHreflangRule) Name() string { return "broken-hreflang" }
+
+func (r BrokenHreflangRule) Check(c *AuditContext, p Page) []Issue {
+	var issues []Issue
+	for _, a := range p.Alternates {
+		resp, ok := c.Responses[a.URL]
+		if !ok {
+			continue
+		}
+		switch {
+		case resp.Err != nil:
+			issues = append(issues, Issue{r.Name(), SeverityError, fmt.Sprintf("the %s alternate, %s, is broken: %s", a.Hreflang, a.URL, resp.Err)})
+		case len(resp.Redirects) > 0:
+			issues = append(issues, Issue{r.Name(), SeverityError, fmt.Sprintf("the %s alternate, %s, returned %d, a redirect to %s", a.Hreflang, a.URL, resp.Redirects[0].StatusCode, resp.Redirects[len(resp.Redirects)-1].Location)})
+		case resp.StatusCode != http.StatusOK:
+			issues = append(issues, Issue{r.Name(), SeverityError, fmt.Sprintf("the %s alternate, %s, returned %d, not 200", a.Hreflang, a.URL, resp.StatusCode)})
+		}
+	}
+	return issues
+}
+
+// ThinContentRule finds pages with fewer than MinWords words.
+type ThinContentRule struct {
+	MinWords int
+}
+
+func (ThinContentRule) Name() string { return "thin-content" }
+
+func (ThinContentRule) ChecksContent() {}
+
+func (r ThinContentRule) Check(c *AuditContext, p Page) []Issue {
+	if p.WordCount >= r.MinWords {
+		return nil
+	}
+	return []Issue{{r.Name(), SeverityNotice, fmt.Sprintf("the page has %d words, the min is %d", p.WordCount, r.MinWords)}}
+}
+
+// sameURL returns whether the urls are the same once normalized.
+func sameURL(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return a == b
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return a == b
+	}
+	return normalizeURL(ua) == normalizeURL(ub)
+}
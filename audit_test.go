@@ -0,0 +1,225 @@
+package geomi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// page returns an html Page for u with the content.
+func page(u string, c Content, links ...string) Page {
+	pu, _ := url.Parse(u)
+	return Page{URL: pu, Content: c, contentExtracted: true, links: links}
+}
+
+func TestRules(t *testing.T) {
+	html := ResponseInfo{StatusCode: 200, ContentType: "text/html"}
+	c := &AuditContext{
+		Pages: map[string]Page{
+			"http://golang.org/a": page("http://golang.org/a", Content{Title: "Go"}),
+			"http://golang.org/b": page("http://golang.org/b", Content{Title: "Go", Canonical: "http://golang.org/a"}),
+			"http://golang.org/c": page("http://golang.org/c", Content{Title: "Go"}),
+			"http://golang.org/d": page("http://golang.org/d", Content{Title: "Doc", Canonical: "http://GOLANG.org:80/d"}),
+		},
+		Responses: map[string]ResponseInfo{
+			"http://golang.org/a":  html,
+			"http://golang.org/b":  html,
+			"http://golang.org/c":  {StatusCode: 200, ContentType: "image/png"},
+			"http://golang.org/d":  html,
+			"http://golang.org/ko": {StatusCode: 404},
+		},
+	}
+	tests := []struct {
+		rule     Rule
+		page     Page
+		expected []Issue
+	}{
+		{MissingTitleRule{}, page("http://golang.org/x", Content{}), []Issue{{"missing-title", SeverityError, "the page has no title"}}},
+		{MissingTitleRule{}, page("http://golang.org/x", Content{Title: "x"}), nil},
+		// c isn't html, so it doesn't count
+		{DuplicateTitleRule{}, c.Pages["http://golang.org/a"], []Issue{{"duplicate-title", SeverityWarning, "the title is also used by http://golang.org/b"}}},
+		{DuplicateTitleRule{}, c.Pages["http://golang.org/d"], nil},
+		{TitleLengthRule{MaxLength: 5}, page("http://golang.org/x", Content{Title: "거미 거미 거미"}), []Issue{{"title-too-long", SeverityWarning, "the title is 8 characters, the max is 5"}}},
+		{TitleLengthRule{MaxLength: 8}, page("http://golang.org/x", Content{Title: "거미 거미 거미"}), nil},
+		{MissingDescriptionRule{}, page("http://golang.org/x", Content{}), []Issue{{"missing-description", SeverityWarning, "the page has no meta description"}}},
+		{MissingDescriptionRule{}, page("http://golang.org/x", Content{Description: "x"}), nil},
+		{MultipleH1Rule{}, page("http://golang.org/x", Content{Headings: []Heading{{1, "a"}, {2, "b"}, {1, "c"}}}), []Issue{{"multiple-h1", SeverityWarning, "the page has 2 h1 elements"}}},
+		{MultipleH1Rule{}, page("http://golang.org/x", Content{Headings: []Heading{{1, "a"}, {2, "b"}}}), nil},
+		{MissingAltRule{}, page("http://golang.org/x", Content{Images: []Image{{"a.png", "", false}, {"b.png", "", true}}}), []Issue{{"missing-alt", SeverityWarning, "image a.png has no alt text"}}},
+		{NonCanonicalLinkRule{}, page("http://golang.org/x", Content{}, "http://golang.org/a", "http://golang.org/b", "http://golang.org/b", "http://golang.org/d"), []Issue{{"non-canonical-link", SeverityNotice, "link to http://golang.org/b, whose canonical url is http://golang.org/a"}}},
+		{BrokenHreflangRule{}, page("http://golang.org/x", Content{Alternates: []Alternate{{"en", "http://golang.org/a"}, {"ko", "http://golang.org/ko"}, {"ja", "http://golang.org/ja"}}}), []Issue{{"broken-hreflang", SeverityError, "the ko alternate, http://golang.org/ko, returned 404, not 200"}}},
+		{ThinContentRule{MinWords: 10}, page("http://golang.org/x", Content{WordCount: 9}), []Issue{{"thin-content", SeverityNotice, "the page has 9 words, the min is 10"}}},
+		{ThinContentRule{MinWords: 10}, page("http://golang.org/x", Content{WordCount: 10}), nil},
+	}
+	for _, test := range tests {
+		issues := test.rule.Check(c, test.page)
+		if !reflect.DeepEqual(issues, test.expected) {
+			t.Errorf("%s: %s: expected %v, got %v", test.rule.Name(), test.page.URL, test.expected, issues)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	html := ResponseInfo{StatusCode: 200, ContentType: "text/html"}
+	long := "<title>" + strings.Repeat("long ", 20) + "</title>"
+	f := statusFetcher{
+		"http://golang.org/doc/": {
+			`<title>Docs</title><meta name="description" content="docs"><h1>Docs</h1><p>` + strings.Repeat("word ", 300) + `</p>`,
+			html,
+			[]string{"http://golang.org/doc/a", "http://golang.org/doc/logo.png"},
+		},
+		"http://golang.org/doc/a":        {long + `<h1>a</h1><h1>b</h1><img src="x.png">`, html, nil},
+		"http://golang.org/doc/logo.png": {"", ResponseInfo{StatusCode: 200, ContentType: "image/png"}, nil},
+	}
+	s := crawledSpider(t, "http://golang.org/doc/", AdaptFetcher(f))
+	tests := []struct {
+		rules    []Rule // nil is the default rules
+		url      string
+		expected []string // the rules with issues, sorted by severity
+	}{
+		{nil, "http://golang.org/doc/", nil},
+		{nil, "http://golang.org/doc/a", []string{"missing-alt", "missing-description", "multiple-h1", "title-too-long", "thin-content"}},
+		// only html is audited
+		{nil, "http://golang.org/doc/logo.png", nil},
+		// only the rules that are passed are run
+		{[]Rule{MultipleH1Rule{}}, "http://golang.org/doc/a", []string{"multiple-h1"}},
+	}
+	for _, test := range tests {
+		var rules []string
+		var last Severity = SeverityError
+		issues := s.Audit(test.rules...)[test.url]
+		for _, i := range issues {
+			rules = append(rules, i.Rule)
+			if i.Severity > last {
+				t.Errorf("%s: expected the issues to be sorted by severity, got %v", test.url, issues)
+			}
+			last = i.Severity
+		}
+		if !reflect.DeepEqual(rules, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.url, test.expected, rules)
+		}
+	}
+}
+
+func TestAuditWithoutContent(t *testing.T) {
+	html := ResponseInfo{StatusCode: 200, ContentType: "text/html"}
+	f := statusFetcher{
+		"http://golang.org/doc/": {
+			`<title>Docs</title><a href="/doc/missing">missing</a><a href="/doc/a">a</a>`,
+			html,
+			[]string{"http://golang.org/doc/missing", "http://golang.org/doc/a"},
+		},
+		"http://golang.org/doc/a":       {`<h1>a</h1>`, html, nil},
+		"http://golang.org/doc/missing": {`<h1>Not Found</h1>`, ResponseInfo{Status: "404 Not Found", StatusCode: 404, ContentType: "text/html"}, nil},
+	}
+	tests := []struct {
+		extract  bool
+		expected []string // the pages with issues
+	}{
+		// the 404 has no content to audit
+		{true, []string{"http://golang.org/doc/", "http://golang.org/doc/a"}},
+		// nothing was extracted, so nothing is missing
+		{false, nil},
+	}
+	for _, test := range tests {
+		s := crawledSpider(t, "http://golang.org/doc/", AdaptFetcher(f), func(c *Config) { c.ExtractContent = test.extract })
+		var pages []string
+		for k := range s.Audit() {
+			pages = append(pages, k)
+		}
+		sort.Strings(pages)
+		if !reflect.DeepEqual(pages, test.expected) {
+			t.Errorf("extract %t: expected issues for %v, got %v", test.extract, test.expected, pages)
+		}
+	}
+}
+
+// noH1Rule is a custom rule that finds pages without an h1.
+type noH1Rule struct{}
+
+func (noH1Rule) Name() string { return "no-h1" }
+
+func (noH1Rule) Check(c *AuditContext, p Page) []Issue {
+	for _, h := range p.Headings {
+		if h.Level == 1 {
+			return nil
+		}
+	}
+	return []Issue{{"no-h1", SeverityWarning, "the page has no h1"}}
+}
+
+// noH1ContentRule is noH1Rule as a ContentRule.
+type noH1ContentRule struct{ noH1Rule }
+
+func (noH1ContentRule) ChecksContent() {}
+
+func TestAuditCustomContentRule(t *testing.T) {
+	html := ResponseInfo{StatusCode: 200, ContentType: "text/html"}
+	f := statusFetcher{
+		"http://golang.org/doc/":        {`<h1>Docs</h1><a href="/doc/missing">missing</a>`, html, []string{"http://golang.org/doc/missing"}},
+		"http://golang.org/doc/missing": {`<p>Not Found</p>`, ResponseInfo{Status: "404 Not Found", StatusCode: 404, ContentType: "text/html"}, nil},
+	}
+	tests := []struct {
+		rule     Rule
+		extract  bool
+		expected []string // the pages with issues
+	}{
+		// a rule that isn't a ContentRule is run on every page
+		{noH1Rule{}, true, []string{"http://golang.org/doc/missing"}},
+		{noH1Rule{}, false, []string{"http://golang.org/doc/", "http://golang.org/doc/missing"}},
+		// a ContentRule is only run on the pages with content
+		{noH1ContentRule{}, true, nil},
+		{noH1ContentRule{}, false, nil},
+	}
+	for _, test := range tests {
+		s := crawledSpider(t, "http://golang.org/doc/", AdaptFetcher(f), func(c *Config) { c.ExtractContent = test.extract })
+		var pages []string
+		for k := range s.Audit(test.rule) {
+			pages = append(pages, k)
+		}
+		sort.Strings(pages)
+		if !reflect.DeepEqual(pages, test.expected) {
+			t.Errorf("%T, extract %t: expected issues for %v, got %v", test.rule, test.extract, test.expected, pages)
+		}
+	}
+}
+
+func TestBrokenHreflangRedirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old/":
+			http.Redirect(w, r, "/ko/", http.StatusMovedPermanently)
+			return
+		case "/ko/":
+			fmt.Fprint(w, `<html><head><link rel="alternate" hreflang="en" href="/"></head></html>`)
+			return
+		}
+		fmt.Fprint(w, `<html><head><link rel="alternate" hreflang="ko" href="/old/"></head></html>`)
+	}))
+	defer ts.Close()
+	s, err := NewSpider(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Config.SetFetchInterval(0)
+	s.Config.CheckExternalLinks = false
+	s.Config.RespectRobots = false
+	if _, err := s.Crawl(-1); err != nil {
+		t.Fatal(err)
+	}
+	r := s.fetchedURLs[ts.URL+"/old/"]
+	redirects := []Redirect{{ts.URL + "/old/", http.StatusMovedPermanently, ts.URL + "/ko/"}}
+	if !reflect.DeepEqual(r.Redirects, redirects) {
+		t.Errorf("Expected redirects to be %v, got %v", redirects, r.Redirects)
+	}
+	issues := s.Audit(BrokenHreflangRule{})
+	expected := []Issue{{"broken-hreflang", SeverityError, "the ko alternate, " + ts.URL + "/old/, returned 301, a redirect to " + ts.URL + "/ko/"}}
+	if !reflect.DeepEqual(issues[ts.URL+"/"], expected) {
+		t.Errorf("Expected %v, got %v", expected, issues[ts.URL+"/"])
+	}
+}
@@ -0,0 +1,566 @@
+package geomi
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// browserNames are the executables looked for in the PATH when no browser path
+// is given.
+var browserNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"}
+
+// devToolsListening matches the line a browser writes to stderr once its
+// DevTools endpoint is ready.
+var devToolsListening = regexp.MustCompile(`DevTools listening on (ws://[^\s]+)`)
+
+// Browser is a Fetcher, and a RequestFetcher, that renders pages in a headless
+// Chromium, driven over the DevTools protocol, so that the links of pages that
+// are built by JavaScript can be found. Each page is loaded in a new tab; once
+// the page has loaded and the network has been idle for Config.NetworkIdle, the
+// rendered DOM is read and the tab is closed. Only html pages are read, the body
+// of anything else is empty.
+type Browser struct {
+	Endpoint string  // the DevTools http endpoint, e.g. http://127.0.0.1:9222
+	Config   *Config // if nil, the defaults are used
+	cmd      *exec.Cmd
+	dir      string // the user data dir of a browser that was started
+}
+
+// NewBrowser returns a Browser that uses the headless browser whose DevTools
+// endpoint is at endpoint, e.g. one started with:
+//
+//	chromium --headless --remote-debugging-port=9222
+func NewBrowser(endpoint string, c *Config) *Browser {
+	return &Browser{Endpoint: strings.TrimSuffix(endpoint, "/"), Config: c}
+}
+
+// StartBrowser starts the headless browser at path and returns a Browser that
+// uses it. If path is empty, Chromium or Chrome is looked for in the PATH. The
+// browser is stopped by Close.
+func StartBrowser(path string, c *Config) (*Browser, error) {
+	if path == "" {
+		for _, name := range browserNames {
+			if p, err := exec.LookPath(name); err == nil {
+				path = p
+				break
+			}
+		}
+		if path == "" {
+			return nil, errors.New("start browser: no chromium or chrome found in the PATH")
+		}
+	}
+	dir, err := os.MkdirTemp("", "geomi-browser-")
+	if err != nil {
+		return nil, fmt.Errorf("start browser: %w", err)
+	}
+	cmd := exec.Command(path, "--headless", "--disable-gpu", "--no-first-run", "--no-default-browser-check", "--remote-debugging-port=0", "--user-data-dir="+dir, "about:blank")
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("start browser: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("start browser: %w", err)
+	}
+	b := &Browser{Config: c, cmd: cmd, dir: dir}
+	ws := make(chan string, 1)
+	go func() {
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			if m := devToolsListening.FindStringSubmatch(sc.Text()); m != nil {
+				ws <- m[1]
+				break
+			}
+		}
+		close(ws)
+		// the browser blocks if its stderr isn't read
+		io.Copy(io.Discard, stderr)
+	}()
+	select {
+	case u, ok := <-ws:
+		if !ok {
+			b.Close()
+			return nil, fmt.Errorf("start browser: %s exited without starting DevTools", path)
+		}
+		wu, err := url.Parse(u)
+		if err != nil {
+			b.Close()
+			return nil, fmt.Errorf("start browser: %w", err)
+		}
+		b.Endpoint = "http://" + wu.Host
+	case <-time.After(30 * time.Second):
+		b.Close()
+		return nil, fmt.Errorf("start browser: %s didn't start DevTools within 30s", path)
+	}
+	return b, nil
+}
+
+// Close stops the browser, if it was started by StartBrowser.
+func (b *Browser) Close() error {
+	if b.cmd == nil {
+		return nil
+	}
+	b.cmd.Process.Kill()
+	b.cmd.Wait()
+	b.cmd = nil
+	return os.RemoveAll(b.dir)
+}
+
+// Implements fetcher. The ResponseInfo is that of the page's document; the
+// resources the page loads aren't recorded.
+func (b *Browser) Fetch(u string) (body string, r ResponseInfo, urls []string) {
+	res := b.FetchRequest(Request{URL: u})
+	return res.Body, res.ResponseInfo, res.URLs
+}
+
+// FetchRequest implements RequestFetcher. The Request's headers are sent with
+// the page's requests; its User-Agent, if it has one, is used instead of the
+// Config's, and its Referer, or referrer, is the page's referrer. See Fetch.
+func (b *Browser) FetchRequest(req Request) Result {
+	var res Result
+	c := b.Config
+	if c == nil {
+		c = NewConfig()
+	}
+	t, err := b.newTarget()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer b.closeTarget(t.ID)
+	ws, err := websocket.Dial(t.WebSocketDebuggerURL, "", b.Endpoint)
+	if err != nil {
+		res.Err = fmt.Errorf("browser: %w", err)
+		return res
+	}
+	defer ws.Close()
+	if c.RenderTimeout > 0 {
+		ws.SetDeadline(time.Now().Add(c.RenderTimeout))
+	}
+	p := newDevToolsPage(ws)
+	defer p.close()
+	body, r, err := p.render(req, c)
+	res.ResponseInfo = r
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if !isHTML(mediaType(r.ContentType)) {
+		return res
+	}
+	base, err := url.Parse(p.docURL)
+	if err != nil {
+		base, _ = url.Parse(req.URL)
+	}
+	urls, err := linksFromReader(base, strings.NewReader(body))
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Body = body
+	res.URLs = urls
+	return res
+}
+
+// devToolsTarget is a tab, as returned by the DevTools http endpoint.
+type devToolsTarget struct {
+	ID                   string `json:"id"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// newTarget opens a new tab.
+func (b *Browser) newTarget() (devToolsTarget, error) {
+	var t devToolsTarget
+	u := b.Endpoint + "/json/new?about:blank"
+	// newer browsers only allow PUT
+	req, _ := http.NewRequest("PUT", u, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		resp, err = http.Get(u)
+	}
+	if err != nil {
+		return t, fmt.Errorf("browser: opening a tab: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return t, fmt.Errorf("browser: opening a tab: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return t, fmt.Errorf("browser: opening a tab: %w", err)
+	}
+	if t.WebSocketDebuggerURL == "" {
+		return t, errors.New("browser: opening a tab: no websocket url")
+	}
+	return t, nil
+}
+
+// closeTarget closes the tab.
+func (b *Browser) closeTarget(id string) {
+	resp, err := http.Get(b.Endpoint + "/json/close/" + url.PathEscape(id))
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+// devToolsMessage is a DevTools protocol message: a command, the response to a
+// command, or an event.
+type devToolsMessage struct {
+	ID     int             `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// devToolsResponse is a resource's response, from a Network.responseReceived
+// event.
+type devToolsResponse struct {
+	URL        string            `json:"url"`
+	Status     int               `json:"status"`
+	StatusText string            `json:"statusText"`
+	Headers    map[string]string `json:"headers"`
+	MimeType   string            `json:"mimeType"`
+}
+
+// devToolsPage is the DevTools session of a tab. Messages are read by a
+// goroutine; everything else happens on the caller's.
+type devToolsPage struct {
+	ws       *websocket.Conn
+	msgs     chan devToolsMessage
+	done     chan struct{}
+	err      error // why reading stopped; set before msgs is closed
+	id       int   // the id of the last command
+	inflight map[string]bool
+	loaded   bool
+	docID    string // the request id of the page's document
+	docURL   string // the document's url, after any redirects
+	doc      *devToolsResponse
+	docErr   string
+}
+
+func newDevToolsPage(ws *websocket.Conn) *devToolsPage {
+	p := &devToolsPage{ws: ws, msgs: make(chan devToolsMessage), done: make(chan struct{}), inflight: make(map[string]bool)}
+	go p.read()
+	return p
+}
+
+// read reads messages until the connection is closed or fails.
+func (p *devToolsPage) read() {
+	defer close(p.msgs)
+	for {
+		var m devToolsMessage
+		if err := websocket.JSON.Receive(p.ws, &m); err != nil {
+			p.err = err
+			return
+		}
+		select {
+		case p.msgs <- m:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *devToolsPage) close() {
+	close(p.done)
+}
+
+// next returns the next message.
+func (p *devToolsPage) next() (devToolsMessage, error) {
+	m, ok := <-p.msgs
+	if !ok {
+		return m, fmt.Errorf("browser: %w", p.err)
+	}
+	return m, nil
+}
+
+// call sends the command and waits for its response, handling any events
+// received in the meantime. The result is unmarshaled into result, if it isn't
+// nil.
+func (p *devToolsPage) call(method string, params, result interface{}) error {
+	p.id++
+	m := map[string]interface{}{"id": p.id, "method": method}
+	if params != nil {
+		m["params"] = params
+	}
+	if err := websocket.JSON.Send(p.ws, m); err != nil {
+		return fmt.Errorf("browser: %s: %w", method, err)
+	}
+	for {
+		m, err := p.next()
+		if err != nil {
+			return err
+		}
+		if m.ID != p.id {
+			p.event(m)
+			continue
+		}
+		if m.Error != nil {
+			return fmt.Errorf("browser: %s: %s", method, m.Error.Message)
+		}
+		if result != nil {
+			return json.Unmarshal(m.Result, result)
+		}
+		return nil
+	}
+}
+
+// event updates the page's state from a DevTools event.
+func (p *devToolsPage) event(m devToolsMessage) {
+	var e struct {
+		RequestID string               `json:"requestId"`
+		Type      string               `json:"type"`
+		Request   struct{ URL string } `json:"request"`
+		Response  devToolsResponse     `json:"response"`
+		ErrorText string               `json:"errorText"`
+		Canceled  bool                 `json:"canceled"`
+	}
+	if len(m.Params) > 0 {
+		json.Unmarshal(m.Params, &e)
+	}
+	switch m.Method {
+	case "Network.requestWillBeSent":
+		p.inflight[e.RequestID] = true
+		if p.docID == "" && e.Type == "Document" {
+			p.docID = e.RequestID
+		}
+		if e.RequestID == p.docID {
+			p.docURL = e.Request.URL
+		}
+	case "Network.responseReceived":
+		if e.RequestID == p.docID {
+			res := e.Response
+			p.doc = &res
+			p.docURL = res.URL
+		}
+	case "Network.loadingFinished":
+		delete(p.inflight, e.RequestID)
+	case "Network.loadingFailed":
+		delete(p.inflight, e.RequestID)
+		if e.RequestID == p.docID && !e.Canceled {
+			p.docErr = e.ErrorText
+		}
+	case "Page.loadEventFired":
+		p.loaded = true
+	}
+}
+
+// render loads the request's url, with its headers, and returns its rendered
+// html along with the response info of its document.
+func (p *devToolsPage) render(req Request, c *Config) (string, ResponseInfo, error) {
+	var r ResponseInfo
+	for _, m := range []string{"Network.enable", "Page.enable"} {
+		if err := p.call(m, nil, nil); err != nil {
+			return "", r, err
+		}
+	}
+	ua := req.Header.Get("User-Agent")
+	if ua == "" {
+		ua = c.UserAgent
+	}
+	if ua != "" {
+		if err := p.call("Network.setUserAgentOverride", map[string]string{"userAgent": ua}, nil); err != nil {
+			return "", r, err
+		}
+	}
+	// the browser sets its own User-Agent, Referer, and Accept-Encoding
+	headers := make(map[string]string)
+	for k, v := range req.Header {
+		switch http.CanonicalHeaderKey(k) {
+		case "User-Agent", "Referer", "Accept-Encoding":
+			continue
+		}
+		headers[k] = strings.Join(v, ", ")
+	}
+	if len(headers) > 0 {
+		if err := p.call("Network.setExtraHTTPHeaders", map[string]interface{}{"headers": headers}, nil); err != nil {
+			return "", r, err
+		}
+	}
+	nav := map[string]string{"url": req.URL}
+	if ref := req.Header.Get("Referer"); ref != "" {
+		nav["referrer"] = ref
+	} else if req.Referrer != "" {
+		nav["referrer"] = req.Referrer
+	}
+	var navigated struct {
+		ErrorText string `json:"errorText"`
+	}
+	if err := p.call("Page.navigate", nav, &navigated); err != nil {
+		return "", r, err
+	}
+	if navigated.ErrorText != "" {
+		return "", r, fmt.Errorf("browser: %s", navigated.ErrorText)
+	}
+	if err := p.waitIdle(c.NetworkIdle, maxIdleWait); err != nil {
+		return "", r, err
+	}
+	if p.docErr != "" {
+		return "", r, fmt.Errorf("browser: %s", p.docErr)
+	}
+	if p.doc == nil {
+		return "", r, errors.New("browser: no response was received for the page")
+	}
+	r.StatusCode = p.doc.Status
+	text := p.doc.StatusText
+	if text == "" {
+		text = http.StatusText(p.doc.Status)
+	}
+	r.Status = fmt.Sprintf("%d %s", p.doc.Status, text)
+	r.Header = make(http.Header, len(p.doc.Headers))
+	for k, v := range p.doc.Headers {
+		// headers that occur more than once are joined with newlines
+		for _, s := range strings.Split(v, "\n") {
+			r.Header.Add(k, s)
+		}
+	}
+	r.ContentType = r.Header.Get("Content-Type")
+	if r.ContentType == "" {
+		r.ContentType = p.doc.MimeType
+	}
+	r.ContentLength = -1
+	if !isHTML(mediaType(r.ContentType)) {
+		return "", r, nil
+	}
+	var eval struct {
+		Result struct {
+			Value string `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}
+	err := p.call("Runtime.evaluate", map[string]interface{}{"expression": "document.documentElement.outerHTML", "returnByValue": true}, &eval)
+	if err != nil {
+		return "", r, err
+	}
+	if eval.ExceptionDetails != nil {
+		return "", r, fmt.Errorf("browser: reading the page: %s", eval.ExceptionDetails.Text)
+	}
+	r.BodyBytes = int64(len(eval.Result.Value))
+	return eval.Result.Value, r, nil
+}
+
+// maxIdleWait is the longest waitIdle waits. A page that polls, or holds a
+// connection open, never goes idle; without a RenderTimeout, nothing else would
+// stop the wait.
+var maxIdleWait = time.Minute
+
+// waitIdle waits until the page has loaded and there have been no network
+// requests in flight for the idle time, or, if that doesn't happen, for max;
+// either way, the page is read as it is.
+func (p *devToolsPage) waitIdle(idle, max time.Duration) error {
+	ceiling := time.After(max)
+	for {
+		var quiet <-chan time.Time
+		if p.loaded && len(p.inflight) == 0 {
+			quiet = time.After(idle)
+		}
+		select {
+		case m, ok := <-p.msgs:
+			if !ok {
+				return fmt.Errorf("browser: waiting for the page to load: %w", p.err)
+			}
+			p.event(m)
+		case <-quiet:
+			return nil
+		case <-ceiling:
+			return nil
+		}
+	}
+}
+
+// renderFetcher fetches pages with the site, except for the html pages that are
+// to be rendered, which are fetched with the browser. Whether a page is html is
+// found with a HEAD request before it's fetched.
+type renderFetcher struct {
+	site     RequestFetcher
+	browser  RequestFetcher
+	all      bool                   // whether every page is rendered
+	patterns []*regexp.Regexp       // the urls of the pages that are rendered
+	isHTML   func(req Request) bool // whether the request is for html; headHTML, unless a test replaces it
+}
+
+// newRenderFetcher returns a renderFetcher for the config's RenderAll and
+// RenderPatterns. The browser must be set before it's used.
+func newRenderFetcher(c *Config, site RequestFetcher) (*renderFetcher, error) {
+	f := &renderFetcher{site: site, all: c.RenderAll, isHTML: headHTML}
+	for _, p := range c.RenderPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("render pattern %q: %w", p, err)
+		}
+		f.patterns = append(f.patterns, re)
+	}
+	return f, nil
+}
+
+// FetchRequest implements RequestFetcher.
+func (f *renderFetcher) FetchRequest(req Request) Result {
+	if f.render(req.URL) && f.isHTML(req) {
+		return f.browser.FetchRequest(req)
+	}
+	return f.site.FetchRequest(req)
+}
+
+// render returns whether the url is to be rendered in the browser.
+func (f *renderFetcher) render(u string) bool {
+	if f.all {
+		return true
+	}
+	for _, re := range f.patterns {
+		if re.MatchString(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// headHTML returns whether a HEAD request, with the request's headers, says the
+// url is html. If it can't tell, e.g. the server doesn't support HEAD, or doesn't
+// send a Content-Type, the url is assumed to be html.
+func headHTML(req Request) bool {
+	hr, err := http.NewRequest("HEAD", req.URL, nil)
+	if err != nil {
+		return true
+	}
+	for k, v := range req.Header {
+		hr.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(hr)
+	if err != nil {
+		return true
+	}
+	resp.Body.Close()
+	ct := resp.Header.Get("Content-Type")
+	if resp.StatusCode >= 400 || ct == "" {
+		return true
+	}
+	return isHTML(mediaType(ct))
+}
+
+// browser returns the Browser used to render pages: the one at BrowserURL, if
+// it's set, otherwise a newly started one.
+func (s *Spider) browser() (*Browser, error) {
+	if s.Config.BrowserURL != "" {
+		return NewBrowser(s.Config.BrowserURL, s.Config), nil
+	}
+	return StartBrowser(s.Config.BrowserPath, s.Config)
+}
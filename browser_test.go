@@ -0,0 +1,279 @@
+package geomi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// fakeDevTools is a DevTools endpoint with a single tab. Navigating loads the
+// page's document, then a script that adds a link once the page has loaded.
+type fakeDevTools struct {
+	*httptest.Server
+	status   int
+	mu       sync.Mutex
+	methods  []string          // the commands received, in order
+	closed   []string          // the ids of the tabs that were closed
+	headers  map[string]string // the extra headers that were set
+	referrer string            // the referrer the page was navigated with
+	idle     bool              // whether the network goes idle once the page has loaded
+}
+
+func newFakeDevTools(status int) *fakeDevTools {
+	d := &fakeDevTools{status: status, idle: true}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json/new", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.Error(w, "use PUT", http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id": "1", "webSocketDebuggerUrl": "ws" + strings.TrimPrefix(d.URL, "http") + "/devtools/page/1"})
+	})
+	mux.HandleFunc("/json/close/", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		d.closed = append(d.closed, strings.TrimPrefix(r.URL.Path, "/json/close/"))
+		d.mu.Unlock()
+	})
+	mux.Handle("/devtools/page/1", websocket.Handler(d.serve))
+	d.Server = httptest.NewServer(mux)
+	return d
+}
+
+func (d *fakeDevTools) serve(ws *websocket.Conn) {
+	event := func(method string, params interface{}) {
+		websocket.JSON.Send(ws, map[string]interface{}{"method": method, "params": params})
+	}
+	var url string
+	for {
+		var m struct {
+			ID     int    `json:"id"`
+			Method string `json:"method"`
+			Params struct {
+				URL      string            `json:"url"`
+				Referrer string            `json:"referrer"`
+				Headers  map[string]string `json:"headers"`
+			} `json:"params"`
+		}
+		if err := websocket.JSON.Receive(ws, &m); err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.methods = append(d.methods, m.Method)
+		switch m.Method {
+		case "Network.setExtraHTTPHeaders":
+			d.headers = m.Params.Headers
+		case "Page.navigate":
+			d.referrer = m.Params.Referrer
+		}
+		idle := d.idle
+		d.mu.Unlock()
+		result := map[string]interface{}{}
+		switch m.Method {
+		case "Page.navigate":
+			url = m.Params.URL
+			event("Network.requestWillBeSent", map[string]interface{}{"requestId": "doc", "type": "Document", "request": map[string]string{"url": url}})
+			result = map[string]interface{}{"frameId": "f"}
+		case "Runtime.evaluate":
+			result = map[string]interface{}{"result": map[string]string{"type": "string", "value": `<html><head></head><body><div id="app"><a href="/app/a">A</a><a href="b">B</a></div></body></html>`}}
+		}
+		websocket.JSON.Send(ws, map[string]interface{}{"id": m.ID, "result": result})
+		if m.Method == "Page.navigate" {
+			event("Network.responseReceived", map[string]interface{}{"requestId": "doc", "type": "Document", "response": map[string]interface{}{
+				"url": url, "status": d.status, "statusText": "", "mimeType": "text/html",
+				"headers": map[string]string{"content-type": "text/html; charset=utf-8", "set-cookie": "a=1\nb=2"},
+			}})
+			event("Network.loadingFinished", map[string]string{"requestId": "doc"})
+			event("Network.requestWillBeSent", map[string]interface{}{"requestId": "xhr", "type": "XHR", "request": map[string]string{"url": url + "api"}})
+			event("Page.loadEventFired", map[string]float64{"timestamp": 1})
+			// the xhr finishes after the load event; the page isn't read until
+			// it has
+			if idle {
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					event("Network.loadingFinished", map[string]string{"requestId": "xhr"})
+				}()
+			}
+		}
+	}
+}
+
+func TestBrowserFetch(t *testing.T) {
+	d := newFakeDevTools(200)
+	defer d.Close()
+	c := NewConfig()
+	c.NetworkIdle = 10 * time.Millisecond
+	b := NewBrowser(d.URL+"/", c)
+	start := time.Now()
+	body, r, urls := b.Fetch("http://golang.org/app/")
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Expected the page to be read after the network was idle, it took %s", time.Since(start))
+	}
+	if !strings.Contains(body, `<div id="app">`) {
+		t.Errorf("Expected the rendered html, got %q", body)
+	}
+	if r.Status != "200 OK" || r.ContentType != "text/html; charset=utf-8" {
+		t.Errorf("Expected a 200 html response, got %q %q", r.Status, r.ContentType)
+	}
+	if cookies := r.Header["Set-Cookie"]; !reflect.DeepEqual(cookies, []string{"a=1", "b=2"}) {
+		t.Errorf("Expected the Set-Cookie headers to be split, got %v", cookies)
+	}
+	expected := []string{"http://golang.org/app/a", "http://golang.org/app/b"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected %v, got %v", expected, urls)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	methods := []string{"Network.enable", "Page.enable", "Network.setUserAgentOverride", "Page.navigate", "Runtime.evaluate"}
+	if !reflect.DeepEqual(d.methods, methods) {
+		t.Errorf("Expected the commands %v, got %v", methods, d.methods)
+	}
+	if !reflect.DeepEqual(d.closed, []string{"1"}) {
+		t.Errorf("Expected the tab to be closed, got %v", d.closed)
+	}
+}
+
+func TestBrowserFetchRequest(t *testing.T) {
+	d := newFakeDevTools(200)
+	defer d.Close()
+	c := NewConfig()
+	c.NetworkIdle = 10 * time.Millisecond
+	req := Request{
+		URL:      "http://golang.org/app/",
+		Referrer: "http://golang.org/",
+		Header:   http.Header{"User-Agent": {"geomi"}, "Referer": {"http://golang.org/"}, "Accept-Language": {"ko", "en"}},
+	}
+	res := NewBrowser(d.URL, c).FetchRequest(req)
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	methods := []string{"Network.enable", "Page.enable", "Network.setUserAgentOverride", "Network.setExtraHTTPHeaders", "Page.navigate", "Runtime.evaluate"}
+	if !reflect.DeepEqual(d.methods, methods) {
+		t.Errorf("Expected the commands %v, got %v", methods, d.methods)
+	}
+	// the User-Agent and Referer are set by the browser
+	if headers := map[string]string{"Accept-Language": "ko, en"}; !reflect.DeepEqual(d.headers, headers) {
+		t.Errorf("Expected the headers %v, got %v", headers, d.headers)
+	}
+	if d.referrer != "http://golang.org/" {
+		t.Errorf("Expected the page to be navigated to from http://golang.org/, got %q", d.referrer)
+	}
+}
+
+func TestBrowserFetchNeverIdle(t *testing.T) {
+	d := newFakeDevTools(200)
+	d.idle = false
+	defer d.Close()
+	defer func(max time.Duration) { maxIdleWait = max }(maxIdleWait)
+	maxIdleWait = 100 * time.Millisecond
+	c := NewConfig()
+	c.NetworkIdle = 10 * time.Millisecond
+	c.RenderTimeout = 0
+	body, r, _ := NewBrowser(d.URL, c).Fetch("http://golang.org/app/")
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	// without a RenderTimeout, the page is read once the wait reaches its max
+	if !strings.Contains(body, `<div id="app">`) {
+		t.Errorf("Expected the rendered html, got %q", body)
+	}
+}
+
+func TestBrowserFetchTimeout(t *testing.T) {
+	d := newFakeDevTools(200)
+	defer d.Close()
+	c := NewConfig()
+	// the network never goes idle for long enough
+	c.NetworkIdle = time.Minute
+	c.RenderTimeout = 100 * time.Millisecond
+	_, r, _ := NewBrowser(d.URL, c).Fetch("http://golang.org/app/")
+	if r.Err == nil {
+		t.Error("Expected the render to time out")
+	}
+}
+
+func TestRenderFetcher(t *testing.T) {
+	site := &testFetcher{"http://golang.org/doc/": &testResult{"site", nil}, "http://golang.org/app/": &testResult{"site", nil}, "http://golang.org/app/logo.png": &testResult{"site", nil}}
+	browser := &testFetcher{"http://golang.org/doc/": &testResult{"browser", nil}, "http://golang.org/app/": &testResult{"browser", nil}, "http://golang.org/app/logo.png": &testResult{"browser", nil}}
+	tests := []struct {
+		all      bool
+		patterns []string
+		expected map[string]string
+	}{
+		{false, []string{"/app/"}, map[string]string{"http://golang.org/doc/": "site", "http://golang.org/app/": "browser", "http://golang.org/app/logo.png": "site"}},
+		// only html is rendered
+		{true, nil, map[string]string{"http://golang.org/doc/": "browser", "http://golang.org/app/": "browser", "http://golang.org/app/logo.png": "site"}},
+	}
+	for _, test := range tests {
+		c := NewConfig()
+		c.RenderAll = test.all
+		c.RenderPatterns = test.patterns
+		f, err := newRenderFetcher(c, AdaptFetcher(site))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.browser = AdaptFetcher(browser)
+		f.isHTML = func(req Request) bool { return !strings.HasSuffix(req.URL, ".png") }
+		for u, expected := range test.expected {
+			if res := f.FetchRequest(Request{URL: u}); res.Body != expected {
+				t.Errorf("%v %v: %s: expected the %s to be used, got the %s", test.all, test.patterns, u, expected, res.Body)
+			}
+		}
+	}
+	c := NewConfig()
+	c.RenderPatterns = []string{"("}
+	if _, err := newRenderFetcher(c, AdaptFetcher(site)); err == nil {
+		t.Error("Expected an invalid pattern to be an error")
+	}
+}
+
+func TestHeadHTML(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" {
+			t.Errorf("Expected a HEAD request, got %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/nohead":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case "/private":
+			if r.Header.Get("Authorization") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+	}))
+	defer ts.Close()
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"/", true},
+		{"/logo.png", false},
+		// if it can't be told, it's html
+		{"/nohead", true},
+		// the request's headers are sent
+		{"/private", false},
+	}
+	for _, test := range tests {
+		req := Request{URL: ts.URL + test.path, Header: http.Header{"Authorization": {"Bearer gopher"}}}
+		if html := headHTML(req); html != test.expected {
+			t.Errorf("%s: expected html to be %t, got %t", test.path, test.expected, html)
+		}
+	}
+}
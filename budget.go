@@ -0,0 +1,165 @@
+package geomi
+
+import "time"
+
+// Reasons a crawl is stopped before it has finished.
+const (
+	StopMaxPages             = "max pages reached"
+	StopMaxBytes             = "max bytes reached"
+	StopMaxDuration          = "max duration reached"
+	StopMaxRequestsPerMinute = "max requests per minute reached"
+	StopMaxRequestsPerHour   = "max requests per hour reached"
+	StopMaxRequestsPerDay    = "max requests per day reached"
+)
+
+// tokenBucket allows up to capacity requests at once, refilling at a rate that
+// allows capacity requests per window.
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	rate     float64 // tokens per second
+	last     time.Time
+	reason   string // the stop reason when the bucket is empty
+}
+
+func newTokenBucket(limit int, window time.Duration, now time.Time, reason string) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(limit),
+		tokens:   float64(limit),
+		rate:     float64(limit) / window.Seconds(),
+		last:     now,
+		reason:   reason,
+	}
+}
+
+// wait refills the bucket and returns how long until a token is available.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// budget holds the crawl's limits and tracks how much of them has been used.
+type budget struct {
+	maxPages int
+	maxBytes int64
+	deadline time.Time // zero if there is no limit
+	buckets  []*tokenBucket
+	start    time.Time
+	end      time.Time // zero while the crawl is running
+	requests int
+	pages    int
+	bytes    int64
+}
+
+// newBudget returns a budget for the config's limits starting at now.
+func newBudget(c *Config, now time.Time) *budget {
+	b := &budget{maxPages: c.MaxPages, maxBytes: c.MaxBytes, start: now}
+	if c.MaxDuration > 0 {
+		b.deadline = now.Add(c.MaxDuration)
+	}
+	if c.MaxRequestsPerMinute > 0 {
+		b.buckets = append(b.buckets, newTokenBucket(c.MaxRequestsPerMinute, time.Minute, now, StopMaxRequestsPerMinute))
+	}
+	if c.MaxRequestsPerHour > 0 {
+		b.buckets = append(b.buckets, newTokenBucket(c.MaxRequestsPerHour, time.Hour, now, StopMaxRequestsPerHour))
+	}
+	if c.MaxRequestsPerDay > 0 {
+		b.buckets = append(b.buckets, newTokenBucket(c.MaxRequestsPerDay, 24*time.Hour, now, StopMaxRequestsPerDay))
+	}
+	return b
+}
+
+// exhausted returns the reason the crawl must stop, or an empty string if there
+// is budget left.
+func (b *budget) exhausted(now time.Time) string {
+	switch {
+	case b == nil:
+		return ""
+	case b.maxPages > 0 && b.pages >= b.maxPages:
+		return StopMaxPages
+	case b.maxBytes > 0 && b.bytes >= b.maxBytes:
+		return StopMaxBytes
+	case !b.deadline.IsZero() && !now.Before(b.deadline):
+		return StopMaxDuration
+	}
+	return ""
+}
+
+// takeRequest takes a request from the request budget. If the per minute, hour,
+// or day limit has been reached, it either waits for the limit to allow another
+// request or returns the reason the crawl must stop, depending on the config.
+// A wait that would go past the crawl's max duration stops the crawl instead.
+func (s *Spider) takeRequest() string {
+	b := s.budget
+	if b == nil {
+		return ""
+	}
+	for {
+		now := time.Now()
+		var wait time.Duration
+		var reason string
+		for _, t := range b.buckets {
+			if w := t.wait(now); w > wait {
+				wait, reason = w, t.reason
+			}
+		}
+		if wait == 0 {
+			for _, t := range b.buckets {
+				t.tokens--
+			}
+			b.requests++
+			return ""
+		}
+		if !s.Config.WaitForBudget {
+			return reason
+		}
+		if !b.deadline.IsZero() && now.Add(wait).After(b.deadline) {
+			return StopMaxDuration
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Summary is an overview of a crawl.
+type Summary struct {
+	Pages         int
+	ExternalLinks int
+	ExternalHosts int
+	Requests      int   // the number of requests made for pages, including retries
+	Bytes         int64 // the number of body bytes downloaded
+	Start         time.Time
+	Duration      time.Duration
+	StopReason    string // why the crawl was stopped before it finished, if it was
+}
+
+// Summary returns an overview of the crawl.
+func (s *Spider) Summary() Summary {
+	s.Lock()
+	defer s.Unlock()
+	sum := Summary{
+		Pages:         len(s.Pages),
+		ExternalLinks: len(s.externalLinks),
+		ExternalHosts: len(s.externalHosts),
+		StopReason:    s.stopReason,
+	}
+	if s.budget != nil {
+		sum.Requests = s.budget.requests
+		sum.Bytes = s.budget.bytes
+		sum.Start = s.budget.start
+		end := s.budget.end
+		if end.IsZero() {
+			end = time.Now()
+		}
+		sum.Duration = end.Sub(s.budget.start)
+	}
+	return sum
+}
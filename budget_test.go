@@ -0,0 +1,91 @@
+package geomi
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, time.Minute, now, StopMaxRequestsPerMinute)
+	for i := 0; i < 2; i++ {
+		if w := b.wait(now); w != 0 {
+			t.Fatalf("%d: expected no wait, got %v", i, w)
+		}
+		b.tokens--
+	}
+	if w := b.wait(now); w != 30*time.Second {
+		t.Errorf("Expected a wait of 30s, got %v", w)
+	}
+	if w := b.wait(now.Add(15 * time.Second)); w != 15*time.Second {
+		t.Errorf("Expected a wait of 15s, got %v", w)
+	}
+	if w := b.wait(now.Add(time.Hour)); w != 0 || b.tokens != 2 {
+		t.Errorf("Expected a full bucket, got %v tokens and a wait of %v", b.tokens, w)
+	}
+}
+
+func TestBudgetExhausted(t *testing.T) {
+	now := time.Now()
+	c := NewConfig()
+	c.MaxPages = 2
+	c.MaxBytes = 100
+	c.MaxDuration = time.Minute
+	tests := []struct {
+		pages    int
+		bytes    int64
+		elapsed  time.Duration
+		expected string
+	}{
+		{0, 0, 0, ""},
+		{1, 99, 59 * time.Second, ""},
+		{2, 0, 0, StopMaxPages},
+		{1, 100, 0, StopMaxBytes},
+		{1, 0, time.Minute, StopMaxDuration},
+	}
+	for i, test := range tests {
+		b := newBudget(c, now)
+		b.pages, b.bytes = test.pages, test.bytes
+		if reason := b.exhausted(now.Add(test.elapsed)); reason != test.expected {
+			t.Errorf("%d: expected %q, got %q", i, test.expected, reason)
+		}
+	}
+}
+
+func TestCrawlBudget(t *testing.T) {
+	tests := []struct {
+		maxPages    int
+		maxRequests int
+		wait        bool
+		pages       int
+		reason      string
+	}{
+		{0, 0, true, 7, ""},
+		{3, 0, true, 3, StopMaxPages},
+		{0, 4, false, 4, StopMaxRequestsPerHour},
+	}
+	for i, test := range tests {
+		s, _ := NewSpider("http://golang.org/")
+		s.Config.SetFetchInterval(0)
+		s.Config.MaxPages = test.maxPages
+		s.Config.MaxRequestsPerHour = test.maxRequests
+		s.Config.WaitForBudget = test.wait
+		s.maxDepth = -1
+		u, _ := url.Parse("http://golang.org/")
+		s.Queue.Enqueue(Page{URL: u})
+		if err := s.crawl(AdaptFetcher(tester)); err != nil {
+			t.Fatal(err)
+		}
+		sum := s.Summary()
+		if sum.Pages != test.pages {
+			t.Errorf("%d: expected %d pages, got %d", i, test.pages, sum.Pages)
+		}
+		if sum.Requests != test.pages {
+			t.Errorf("%d: expected %d requests, got %d", i, test.pages, sum.Requests)
+		}
+		if sum.StopReason != test.reason {
+			t.Errorf("%d: expected stop reason %q, got %q", i, test.reason, sum.StopReason)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package geomi
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
+)
+
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// isText returns whether bodies of the media type are text that should be
+// decoded to UTF-8. XML isn't included: it declares its own encoding, which the
+// xml decoder handles.
+func isText(mediaType string) bool {
+	if isHTML(mediaType) {
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/") && !strings.HasSuffix(mediaType, "xml")
+}
+
+// decodeText returns a reader that decodes br's content to UTF-8 and the name of
+// the content's encoding. The encoding is determined by a byte order mark, the
+// Content-Type's charset, or for html, a meta element in the first 1024 bytes;
+// if none of those say, content that is valid UTF-8 is UTF-8 and anything else
+// is windows-1252.
+func decodeText(br *bufio.Reader, contentType string) (io.Reader, string) {
+	peek, _ := br.Peek(1024)
+	enc, name, _ := charset.DetermineEncoding(peek, contentType)
+	// the byte order mark isn't part of the content
+	switch {
+	case bytes.HasPrefix(peek, utf8BOM):
+		br.Discard(len(utf8BOM))
+	case bytes.HasPrefix(peek, []byte{0xfe, 0xff}), bytes.HasPrefix(peek, []byte{0xff, 0xfe}):
+		br.Discard(2)
+	}
+	if name == "utf-8" {
+		return br, name
+	}
+	return transform.NewReader(br, enc.NewDecoder()), name
+}
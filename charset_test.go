@@ -0,0 +1,92 @@
+package geomi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+)
+
+func encode(e encoding.Encoding, s string) string {
+	b, err := e.NewEncoder().String(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestIsText(t *testing.T) {
+	tests := []struct {
+		mediaType string
+		expected  bool
+	}{
+		{"text/html", true},
+		{"application/xhtml+xml", true},
+		{"text/plain", true},
+		{"text/css", true},
+		{"text/xml", false},
+		{"application/rss+xml", false},
+		{"application/pdf", false},
+		{"image/png", false},
+	}
+	for _, test := range tests {
+		if b := isText(test.mediaType); b != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.mediaType, test.expected, b)
+		}
+	}
+}
+
+func TestFetchCharset(t *testing.T) {
+	ko := `<html><a href="/홈">홈페이지</a></html>`
+	tests := []struct {
+		contentType string
+		body        string
+		expected    string
+		charset     string
+	}{
+		{"text/html; charset=utf-8", ko, ko, "utf-8"},
+		{"text/html; charset=euc-kr", encode(korean.EUCKR, ko), ko, "euc-kr"},
+		{"text/html", encode(japanese.ShiftJIS, `<meta charset="shift_jis"><p>日本語</p>`), `<meta charset="shift_jis"><p>日本語</p>`, "shift_jis"},
+		{"text/html", "\xef\xbb\xbf<p>bom</p>", "<p>bom</p>", "utf-8"},
+		{"text/plain", "\xfe\xff\x00h\x00i", "hi", "utf-16be"},
+		{"text/plain", "caf\xe9", "café", "windows-1252"},
+		{"application/pdf", "caf\xe9", "caf\xe9", ""},
+	}
+	for _, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.Write([]byte(test.body))
+		}))
+		s := Site{Config: NewConfig()}
+		body, r, _ := s.Fetch(ts.URL)
+		ts.Close()
+		if r.Err != nil {
+			t.Errorf("%s: expected no error, got %q", test.contentType, r.Err)
+			continue
+		}
+		if body != test.expected {
+			t.Errorf("%s: expected body %q, got %q", test.contentType, test.expected, body)
+		}
+		if r.Charset != test.charset {
+			t.Errorf("%s: expected charset %q, got %q", test.contentType, test.charset, r.Charset)
+		}
+	}
+}
+
+func TestFetchCharsetLinks(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=euc-kr")
+		w.Write([]byte(encode(korean.EUCKR, `<a href="/소개">소개</a>`)))
+	}))
+	defer ts.Close()
+	s := Site{Config: NewConfig()}
+	_, _, urls := s.Fetch(ts.URL)
+	expected := []string{ts.URL + "/%EC%86%8C%EA%B0%9C"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("expected %v, got %v", expected, urls)
+	}
+}
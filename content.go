@@ -0,0 +1,205 @@
+package geomi
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Content is the information extracted from an html page.
+type Content struct {
+	Title       string
+	Description string            // the meta description
+	Keywords    []string          // the meta keywords
+	Headings    []Heading         // the h1-h6 elements, in document order
+	Lang        string            // the html element's lang
+	OpenGraph   map[string]string // the og: meta properties, without the prefix, e.g. "title"
+	Twitter     map[string]string // the twitter: meta tags, without the prefix, e.g. "card"
+	WordCount   int               // the number of words of visible text
+	Images      []Image           // the img elements, in document order
+	Canonical   string            // the canonical url, from link rel="canonical"
+	Alternates  []Alternate       // the hreflang alternates, from link rel="alternate"
+}
+
+// Heading is an h1-h6 element.
+type Heading struct {
+	Level int // 1-6
+	Text  string
+}
+
+// Image is an img element.
+type Image struct {
+	Src    string
+	Alt    string
+	HasAlt bool // whether there's an alt attribute; an empty alt marks an image as decorative
+}
+
+// Alternate is a language or region specific version of a page.
+type Alternate struct {
+	Hreflang string // e.g. "en-US" or "x-default"
+	URL      string
+}
+
+// hiddenElements are the elements whose text isn't visible on the page.
+var hiddenElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"title":    true,
+}
+
+// extractContent returns the Content of the html read from r; urls are resolved
+// against base. html that can't be tokenized results in whatever was extracted
+// up to that point.
+func extractContent(base *url.URL, r io.Reader) Content {
+	return scanHTML(base, r, true).content
+}
+
+// contentScanner extracts the Content from the tokens of an html page, as they
+// are scanned.
+type contentScanner struct {
+	base    *url.URL
+	c       Content
+	title   strings.Builder
+	heading *strings.Builder
+	level   int
+	inTitle bool
+	hidden  int // the number of open hiddenElements
+}
+
+// start handles a start, or self closing, tag.
+func (s *contentScanner) start(tt html.TokenType, tag string, attrs map[string]string) {
+	switch tag {
+	case "html":
+		s.c.Lang = attrs["lang"]
+		if s.c.Lang == "" {
+			s.c.Lang = attrs["xml:lang"]
+		}
+	case "meta":
+		s.c.addMeta(attrs)
+	case "link":
+		s.c.addLink(s.base, attrs)
+	case "img":
+		alt, ok := attrs["alt"]
+		s.c.Images = append(s.c.Images, Image{Src: resolveRef(s.base, attrs["src"]), Alt: alt, HasAlt: ok})
+	case "title":
+		s.inTitle = tt == html.StartTagToken
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		if tt == html.StartTagToken {
+			s.level = int(tag[1] - '0')
+			s.heading = &strings.Builder{}
+		}
+	}
+	if hiddenElements[tag] && tt == html.StartTagToken {
+		s.hidden++
+	}
+}
+
+// end handles an end tag.
+func (s *contentScanner) end(tag string) {
+	switch tag {
+	case "title":
+		// only the first title counts
+		if s.c.Title == "" {
+			s.c.Title = collapseSpace(s.title.String())
+		}
+		s.title.Reset()
+		s.inTitle = false
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		if s.heading != nil {
+			s.c.Headings = append(s.c.Headings, Heading{Level: s.level, Text: collapseSpace(s.heading.String())})
+			s.heading = nil
+		}
+	}
+	if hiddenElements[tag] && s.hidden > 0 {
+		s.hidden--
+	}
+}
+
+// text handles text.
+func (s *contentScanner) text(text string) {
+	if s.inTitle {
+		s.title.WriteString(text)
+	}
+	if s.hidden > 0 {
+		return
+	}
+	if s.heading != nil {
+		s.heading.WriteString(text)
+	}
+	s.c.WordCount += len(strings.Fields(text))
+}
+
+// done returns the Content; a title that wasn't closed is used if there isn't
+// one.
+func (s *contentScanner) done() Content {
+	if s.c.Title == "" {
+		s.c.Title = collapseSpace(s.title.String())
+	}
+	return s.c
+}
+
+// addMeta adds the meta element's information, if it's something that's
+// extracted.
+func (c *Content) addMeta(attrs map[string]string) {
+	// Open Graph uses property, but name is common too
+	key := strings.ToLower(attrs["property"])
+	if key == "" {
+		key = strings.ToLower(attrs["name"])
+	}
+	val := strings.TrimSpace(attrs["content"])
+	switch {
+	case key == "description":
+		if c.Description == "" {
+			c.Description = val
+		}
+	case key == "keywords":
+		for _, k := range strings.Split(val, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				c.Keywords = append(c.Keywords, k)
+			}
+		}
+	case strings.HasPrefix(key, "og:"):
+		c.OpenGraph = addFirst(c.OpenGraph, key[3:], val)
+	case strings.HasPrefix(key, "twitter:"):
+		c.Twitter = addFirst(c.Twitter, key[8:], val)
+	}
+}
+
+// addLink adds the link element's canonical, if it is one. Its hreflang
+// alternates are found by the htmlScanner, even when the Content isn't
+// extracted.
+func (c *Content) addLink(base *url.URL, attrs map[string]string) {
+	if hasToken(attrs["rel"], "canonical") && c.Canonical == "" {
+		c.Canonical = resolveRef(base, attrs["href"])
+	}
+}
+
+// resolveRef returns ref resolved against base, or ref as is if it can't be
+// resolved.
+func resolveRef(base *url.URL, ref string) string {
+	if u, err := base.Parse(strings.TrimSpace(ref)); err == nil && ref != "" {
+		return u.String()
+	}
+	return ref
+}
+
+// addFirst adds the key to m, creating it if necessary, unless it's already
+// there. Tags like og:image may be repeated, the first is the main one.
+func addFirst(m map[string]string, key, val string) map[string]string {
+	if m == nil {
+		m = make(map[string]string)
+	}
+	if _, ok := m[key]; !ok {
+		m[key] = val
+	}
+	return m
+}
+
+// collapseSpace trims s and replaces each run of white space with a single space.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
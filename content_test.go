@@ -0,0 +1,105 @@
+package geomi
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractContent(t *testing.T) {
+	base, _ := url.Parse("http://golang.org/doc/")
+	tests := []struct {
+		name     string
+		html     string
+		expected Content
+	}{
+		{"empty", "", Content{}},
+		{
+			"full",
+			`<!DOCTYPE html>
+<html lang="en-US"><head>
+<title>  The Go
+  Programming Language </title>
+<meta name="description" content=" Go is an open source language. ">
+<meta name="keywords" content="go, golang,, programming ">
+<meta property="og:title" content="The Go Programming Language">
+<meta property="og:image" content="/first.png">
+<meta property="og:image" content="/second.png">
+<meta name="twitter:card" content="summary">
+<link rel="canonical" href="/doc/">
+<link rel="alternate" hreflang="ko" href="/ko/doc/">
+<link rel="alternate" type="application/rss+xml" href="/feed">
+<script>var words = "not counted";</script>
+<style>p { color: red }</style>
+</head><body>
+<h1>Go <em>is</em> fun</h1>
+<p>Build simple, secure, scalable systems.</p>
+<h2>Download</h2>
+<noscript>enable javascript</noscript>
+<h3>Linux</h3>
+<img src="gopher.png" alt="Gopher"><img src="spacer.gif" alt=""><img src="logo.png">
+</body></html>`,
+			Content{
+				Title:       "The Go Programming Language",
+				Description: "Go is an open source language.",
+				Keywords:    []string{"go", "golang", "programming"},
+				Headings: []Heading{
+					{1, "Go is fun"},
+					{2, "Download"},
+					{3, "Linux"},
+				},
+				Lang:      "en-US",
+				OpenGraph: map[string]string{"title": "The Go Programming Language", "image": "/first.png"},
+				Twitter:   map[string]string{"card": "summary"},
+				WordCount: 10,
+				Images: []Image{
+					{"http://golang.org/doc/gopher.png", "Gopher", true},
+					{"http://golang.org/doc/spacer.gif", "", true},
+					{"http://golang.org/doc/logo.png", "", false},
+				},
+				Canonical:  "http://golang.org/doc/",
+				Alternates: []Alternate{{"ko", "http://golang.org/ko/doc/"}},
+			},
+		},
+		{
+			"xml lang and name og",
+			`<html xml:lang="ko"><meta name="og:type" content="article"><title>a</title><title>b</title><p>one two</p>`,
+			Content{Title: "a", Lang: "ko", OpenGraph: map[string]string{"type": "article"}, WordCount: 2},
+		},
+	}
+	for _, test := range tests {
+		c := extractContent(base, strings.NewReader(test.html))
+		if !reflect.DeepEqual(c, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, c)
+		}
+	}
+}
+
+func TestCrawlContent(t *testing.T) {
+	html := ResponseInfo{StatusCode: 200, ContentType: "text/html; charset=utf-8"}
+	f := statusFetcher{
+		"http://golang.org/doc/":      {`<title>Docs</title><h1>Documentation</h1>`, html, []string{"http://golang.org/doc/a.txt"}},
+		"http://golang.org/doc/a.txt": {"<title>Not html</title>", ResponseInfo{StatusCode: 200, ContentType: "text/plain"}, nil},
+	}
+	tests := []struct {
+		extract bool
+		url     string
+		title   string
+	}{
+		{true, "http://golang.org/doc/", "Docs"},
+		// content is only extracted from html
+		{true, "http://golang.org/doc/a.txt", ""},
+		{false, "http://golang.org/doc/", ""},
+	}
+	for _, test := range tests {
+		s := crawledSpider(t, "http://golang.org/doc/", AdaptFetcher(f), func(c *Config) { c.ExtractContent = test.extract })
+		p := s.Pages[test.url]
+		if p.Title != test.title {
+			t.Errorf("extract %t: %s: expected the title %q, got %q", test.extract, test.url, test.title, p.Title)
+		}
+		if p.contentExtracted != (test.title != "") {
+			t.Errorf("extract %t: %s: expected the content to be extracted to be %t", test.extract, test.url, test.title != "")
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package geomi
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// AcceptEncoding is the Accept-Encoding sent with requests for pages; these are
+// the content encodings that Fetch decodes.
+const AcceptEncoding = "gzip, deflate, br"
+
+// BodyStorage is how the bodies of fetched pages are kept.
+type BodyStorage int
+
+const (
+	BodyInMemory   BodyStorage = iota // bodies are kept in memory as is
+	BodyCompressed                    // bodies are kept in memory, gzipped
+	BodyOnDisk                        // bodies are written, gzipped, to files in the BodyDir
+)
+
+func (b BodyStorage) String() string {
+	switch b {
+	case BodyInMemory:
+		return "memory"
+	case BodyCompressed:
+		return "compressed"
+	case BodyOnDisk:
+		return "disk"
+	}
+	return fmt.Sprintf("BodyStorage(%d)", int(b))
+}
+
+// decodeContent returns a reader that decodes r according to the Content-Encoding.
+// An empty or identity encoding returns r, as does an empty body, e.g. of a 204
+// or 304, whatever its encoding.
+func decodeContent(r io.Reader, contentEncoding string) (io.Reader, error) {
+	enc := strings.ToLower(strings.TrimSpace(contentEncoding))
+	if enc == "" || enc == "identity" {
+		return r, nil
+	}
+	br := bufio.NewReader(r)
+	if _, err := br.Peek(1); err == io.EOF {
+		return br, nil
+	}
+	switch enc {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(br)
+	case "deflate":
+		// deflate is supposed to be zlib wrapped, but some servers send raw
+		// deflate; a zlib header's first byte is 0x?8 and the header is a
+		// multiple of 31
+		b, err := br.Peek(2)
+		if err == nil && b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return brotli.NewReader(br), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding: %q", contentEncoding)
+}
+
+// Body returns the page's body, reading it from wherever it is stored.
+func (p Page) Body() (string, error) {
+	switch {
+	case p.zbody != nil:
+		return gunzip(bytes.NewReader(p.zbody))
+	case p.bodyFile != "":
+		f, err := os.Open(p.bodyFile)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return gunzip(f)
+	}
+	return p.body, nil
+}
+
+// RemoveBodies removes the bodies that were written to disk, when BodyStorage is
+// BodyOnDisk: the temp dir, if one was made because BodyDir wasn't set, or else
+// the files written to BodyDir. The bodies of the spider's pages can't be read
+// afterwards. The caller is responsible for calling it once the bodies are no
+// longer needed.
+func (s *Spider) RemoveBodies() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.bodyDir == "" {
+		return nil
+	}
+	if s.bodyDir != s.Config.BodyDir {
+		if err := os.RemoveAll(s.bodyDir); err != nil {
+			return err
+		}
+		s.bodyDir = ""
+		return nil
+	}
+	for _, p := range s.Pages {
+		if p.bodyFile == "" {
+			continue
+		}
+		if err := os.Remove(p.bodyFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeBody stores the page's body according to the spider's BodyStorage; a
+// temp dir is made for the files if there isn't a BodyDir, see RemoveBodies.
+func (s *Spider) storeBody(p *Page, body string) error {
+	switch s.Config.BodyStorage {
+	case BodyCompressed:
+		var buf bytes.Buffer
+		if err := gzipTo(&buf, body); err != nil {
+			return err
+		}
+		p.zbody = buf.Bytes()
+	case BodyOnDisk:
+		if s.bodyDir == "" {
+			dir := s.Config.BodyDir
+			if dir == "" {
+				var err error
+				if dir, err = os.MkdirTemp("", "geomi"); err != nil {
+					return err
+				}
+			} else if err := os.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+			s.bodyDir = dir
+		}
+		sum := sha1.Sum([]byte(p.URL.String()))
+		name := filepath.Join(s.bodyDir, hex.EncodeToString(sum[:])+".gz")
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := gzipTo(f, body); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		p.bodyFile = name
+	default:
+		p.body = body
+	}
+	return nil
+}
+
+func gzipTo(w io.Writer, s string) error {
+	zw := gzip.NewWriter(w)
+	if _, err := io.WriteString(zw, s); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func gunzip(r io.Reader) (string, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(zr)
+	return string(b), err
+}
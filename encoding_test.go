@@ -0,0 +1,220 @@
+package geomi
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compress returns s encoded with the content encoding.
+func compress(t *testing.T, encoding, s string) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		return []byte(s)
+	}
+	if _, err := io.WriteString(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeContent(t *testing.T) {
+	body := strings.Repeat("<p>hello, gopher</p>", 20)
+	tests := []struct {
+		encoding string
+		header   string
+		err      string
+	}{
+		{"", "", ""},
+		{"", "identity", ""},
+		{"gzip", "gzip", ""},
+		{"gzip", "X-Gzip", ""},
+		{"deflate", "deflate", ""},
+		{"raw deflate", "deflate", ""},
+		{"br", "br", ""},
+		{"", "compress", `unsupported content encoding: "compress"`},
+		{"empty", "gzip", ""},
+		{"empty", "deflate", ""},
+		{"empty", "br", ""},
+	}
+	for _, test := range tests {
+		in, expected := compress(t, test.encoding, body), body
+		if test.encoding == "empty" {
+			in, expected = nil, ""
+		}
+		r, err := decodeContent(bytes.NewReader(in), test.header)
+		if err != nil {
+			if err.Error() != test.err {
+				t.Errorf("%s: expected error %q, got %q", test.header, test.err, err)
+			}
+			continue
+		}
+		if test.err != "" {
+			t.Errorf("%s: expected error %q, got none", test.header, test.err)
+			continue
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: expected no error, got %q", test.header, err)
+			continue
+		}
+		if string(b) != expected {
+			t.Errorf("%s: expected %q, got %q", test.header, expected, b)
+		}
+	}
+}
+
+func TestFetchContentEncoding(t *testing.T) {
+	body := `<html>` + strings.Repeat(`<a href="/a">a</a>`, 50) + `</html>`
+	for _, encoding := range []string{"", "gzip", "deflate", "br"} {
+		b := compress(t, encoding, body)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ae := r.Header.Get("Accept-Encoding"); ae != AcceptEncoding {
+				t.Errorf("%s: expected Accept-Encoding %q, got %q", encoding, AcceptEncoding, ae)
+			}
+			w.Header().Set("Content-Type", "text/html")
+			if encoding != "" {
+				w.Header().Set("Content-Encoding", encoding)
+			}
+			w.Write(b)
+		}))
+		s := Site{Config: NewConfig()}
+		got, r, urls := s.Fetch(ts.URL)
+		ts.Close()
+		if r.Err != nil {
+			t.Errorf("%s: expected no error, got %q", encoding, r.Err)
+			continue
+		}
+		if got != body {
+			t.Errorf("%s: expected %q, got %q", encoding, body, got)
+		}
+		if len(urls) != 50 {
+			t.Errorf("%s: expected 50 urls, got %d", encoding, len(urls))
+		}
+		if r.ContentEncoding != encoding {
+			t.Errorf("%s: expected content encoding %q, got %q", encoding, encoding, r.ContentEncoding)
+		}
+		if r.CompressedBytes != int64(len(b)) {
+			t.Errorf("%s: expected %d compressed bytes, got %d", encoding, len(b), r.CompressedBytes)
+		}
+		if r.BodyBytes != int64(len(body)) {
+			t.Errorf("%s: expected %d body bytes, got %d", encoding, len(body), r.BodyBytes)
+		}
+	}
+}
+
+func TestFetchContentEncodingMaxBodySize(t *testing.T) {
+	// the limit is on the decoded body, a small response can be a large body
+	body := strings.Repeat("a", 1<<16)
+	b := compress(t, "gzip", body)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(b)
+	}))
+	defer ts.Close()
+	c := NewConfig()
+	c.MaxBodySize = 1024
+	s := Site{Config: c}
+	got, r, _ := s.Fetch(ts.URL)
+	if len(got) != 1024 || !r.Truncated {
+		t.Errorf("expected a truncated body of 1024 bytes, got %d bytes and truncated %t", len(got), r.Truncated)
+	}
+}
+
+func TestBodyStorage(t *testing.T) {
+	for _, storage := range []BodyStorage{BodyInMemory, BodyCompressed, BodyOnDisk} {
+		s, err := NewSpider("http://golang.org/cmd/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Config.SetFetchInterval(0)
+		s.Config.CheckExternalLinks = false
+		s.Config.BodyStorage = storage
+		s.Config.BodyDir = t.TempDir()
+		s.maxDepth = -1
+		u, _ := url.Parse("http://golang.org/cmd/")
+		s.Queue.Enqueue(Page{URL: u})
+		if err := s.crawl(AdaptFetcher(linkTester)); err != nil {
+			t.Fatal(err)
+		}
+		for k, p := range s.Pages {
+			body, err := p.Body()
+			if err != nil {
+				t.Errorf("%s: %s: expected no error, got %q", storage, k, err)
+				continue
+			}
+			if expected := (*linkTester)[k].body; body != expected {
+				t.Errorf("%s: %s: expected body %q, got %q", storage, k, expected, body)
+			}
+			if (p.body != "") != (storage == BodyInMemory) {
+				t.Errorf("%s: %s: expected the body to be kept in memory only when storage is memory", storage, k)
+			}
+		}
+		files, _ := os.ReadDir(s.Config.BodyDir)
+		if storage == BodyOnDisk && len(files) != len(s.Pages) {
+			t.Errorf("%s: expected %d files, got %d", storage, len(s.Pages), len(files))
+		}
+	}
+}
+
+func TestFetchEmptyContentEncoding(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	body, r, _ := Site{Config: NewConfig()}.Fetch(ts.URL + "/")
+	if r.Err != nil {
+		t.Fatalf("Expected no error, got %q", r.Err)
+	}
+	if body != "" || r.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected an empty 204, got %d %q", r.StatusCode, body)
+	}
+}
+
+func TestRemoveBodies(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		s := crawledSpider(t, "http://golang.org/cmd/", AdaptFetcher(linkTester), func(c *Config) {
+			c.BodyStorage = BodyOnDisk
+			c.BodyDir = dir
+		})
+		bodyDir := s.bodyDir
+		if err := s.RemoveBodies(); err != nil {
+			t.Fatalf("%q: expected no error, got %q", dir, err)
+		}
+		files, err := os.ReadDir(bodyDir)
+		if dir == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("Expected the temp dir %s to be removed, got %v", bodyDir, err)
+			}
+			continue
+		}
+		if err != nil || len(files) != 0 {
+			t.Errorf("%q: expected the dir to be kept and emptied, got %d files, %v", dir, len(files), err)
+		}
+	}
+}
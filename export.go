@@ -0,0 +1,216 @@
+package geomi
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteDOT writes the graph to w in GraphViz DOT format. Node and edge
+// information is written as attributes so that it is available to tools like
+// Gephi when the file is imported.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "digraph geomi {")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(b, "\t%s [kind=%s, status=%d, distance=%d, content_type=%s];\n", dotQuote(n.URL), n.Kind, n.StatusCode, n.Distance, dotQuote(n.ContentType))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(b, "\t%s -> %s [kind=%s, weight=%d];\n", dotQuote(e.From), dotQuote(e.To), e.Kind, e.Weight)
+	}
+	fmt.Fprintln(b, "}")
+	return b.Flush()
+}
+
+// dotQuote returns s as a quoted DOT ID.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// GraphML document structure.
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// WriteGraphML writes the graph to w in GraphML format.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "url", For: "node", Name: "url", Type: "string"},
+			{ID: "nkind", For: "node", Name: "kind", Type: "string"},
+			{ID: "status", For: "node", Name: "status", Type: "int"},
+			{ID: "distance", For: "node", Name: "distance", Type: "int"},
+			{ID: "content_type", For: "node", Name: "content_type", Type: "string"},
+			{ID: "ekind", For: "edge", Name: "kind", Type: "string"},
+			{ID: "weight", For: "edge", Name: "weight", Type: "int"},
+		},
+		Graph: graphMLGraph{ID: "geomi", EdgeDefault: "directed"},
+	}
+	for i, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: "n" + strconv.Itoa(i),
+			Data: []graphMLData{
+				{"url", n.URL},
+				{"nkind", string(n.Kind)},
+				{"status", strconv.Itoa(n.StatusCode)},
+				{"distance", strconv.Itoa(n.Distance)},
+				{"content_type", n.ContentType},
+			},
+		})
+	}
+	for i, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID:     "e" + strconv.Itoa(i),
+			Source: "n" + strconv.Itoa(g.index[e.From]),
+			Target: "n" + strconv.Itoa(g.index[e.To]),
+			Data: []graphMLData{
+				{"ekind", string(e.Kind)},
+				{"weight", strconv.Itoa(e.Weight)},
+			},
+		})
+	}
+	return writeXML(w, doc)
+}
+
+// GEXF document structure.
+type gexf struct {
+	XMLName xml.Name  `xml:"gexf"`
+	XMLNS   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfGraph struct {
+	Mode            string           `xml:"mode,attr"`
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID        string         `xml:"id,attr"`
+	Source    string         `xml:"source,attr"`
+	Target    string         `xml:"target,attr"`
+	Weight    int            `xml:"weight,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// WriteGEXF writes the graph to w in GEXF 1.3 format, Gephi's native format.
+func (g *Graph) WriteGEXF(w io.Writer) error {
+	doc := gexf{
+		XMLNS:   "http://gexf.net/1.3",
+		Version: "1.3",
+		Graph: gexfGraph{
+			Mode:            "static",
+			DefaultEdgeType: "directed",
+			Attributes: []gexfAttributes{
+				{Class: "node", Attributes: []gexfAttribute{
+					{ID: "kind", Title: "kind", Type: "string"},
+					{ID: "status", Title: "status", Type: "integer"},
+					{ID: "distance", Title: "distance", Type: "integer"},
+					{ID: "content_type", Title: "content_type", Type: "string"},
+				}},
+				{Class: "edge", Attributes: []gexfAttribute{
+					{ID: "kind", Title: "kind", Type: "string"},
+				}},
+			},
+		},
+	}
+	for i, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{
+			ID:    strconv.Itoa(i),
+			Label: n.URL,
+			AttValues: []gexfAttValue{
+				{"kind", string(n.Kind)},
+				{"status", strconv.Itoa(n.StatusCode)},
+				{"distance", strconv.Itoa(n.Distance)},
+				{"content_type", n.ContentType},
+			},
+		})
+	}
+	for i, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+			ID:        strconv.Itoa(i),
+			Source:    strconv.Itoa(g.index[e.From]),
+			Target:    strconv.Itoa(g.index[e.To]),
+			Weight:    e.Weight,
+			AttValues: []gexfAttValue{{"kind", string(e.Kind)}},
+		})
+	}
+	return writeXML(w, doc)
+}
+
+// writeXML writes the xml header followed by the indented document.
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,101 @@
+package geomi
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	g := &Graph{
+		Nodes: []Node{
+			{URL: "http://golang.org/", Kind: LinkInternal, StatusCode: 200, ContentType: "text/html"},
+			{URL: `http://golang.org/"q"`, Kind: LinkSkipped, Distance: 1},
+		},
+		Edges: []Edge{{From: "http://golang.org/", To: `http://golang.org/"q"`, Kind: LinkSkipped, Weight: 1}},
+	}
+	var buf bytes.Buffer
+	if err := g.WriteDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `digraph geomi {
+	"http://golang.org/" [kind=internal, status=200, distance=0, content_type="text/html"];
+	"http://golang.org/\"q\"" [kind=skipped, status=0, distance=1, content_type=""];
+	"http://golang.org/" -> "http://golang.org/\"q\"" [kind=skipped, weight=1];
+}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+// exportGraph is a graph whose edge goes from its second node to its first, so
+// the exporters' node ids have to be looked up, not assumed.
+func exportGraph() *Graph {
+	g := &Graph{
+		Nodes: []Node{
+			{URL: "http://golang.org/", Kind: LinkInternal, StatusCode: 200, ContentType: "text/html", Fetched: true},
+			{URL: "http://golang.org/cmd/", Kind: LinkInternal, Distance: 1, StatusCode: 200, ContentType: "text/html", Fetched: true},
+			{URL: "https://github.com/golang/go", Kind: LinkExternal, Distance: 2},
+		},
+		Edges: []Edge{
+			{From: "http://golang.org/cmd/", To: "http://golang.org/", Kind: LinkInternal, Weight: 2},
+			{From: "http://golang.org/cmd/", To: "https://github.com/golang/go", Kind: LinkExternal, Weight: 1},
+		},
+	}
+	g.reindex()
+	return g
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportGraph().WriteGraphML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc graphML
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Expected valid xml, got %q", err)
+	}
+	nodes := []graphMLNode{
+		{"n0", []graphMLData{{"url", "http://golang.org/"}, {"nkind", "internal"}, {"status", "200"}, {"distance", "0"}, {"content_type", "text/html"}}},
+		{"n1", []graphMLData{{"url", "http://golang.org/cmd/"}, {"nkind", "internal"}, {"status", "200"}, {"distance", "1"}, {"content_type", "text/html"}}},
+		{"n2", []graphMLData{{"url", "https://github.com/golang/go"}, {"nkind", "external"}, {"status", "0"}, {"distance", "2"}, {"content_type", ""}}},
+	}
+	if !reflect.DeepEqual(doc.Graph.Nodes, nodes) {
+		t.Errorf("Expected the nodes %+v, got %+v", nodes, doc.Graph.Nodes)
+	}
+	edges := []graphMLEdge{
+		{"e0", "n1", "n0", []graphMLData{{"ekind", "internal"}, {"weight", "2"}}},
+		{"e1", "n1", "n2", []graphMLData{{"ekind", "external"}, {"weight", "1"}}},
+	}
+	if !reflect.DeepEqual(doc.Graph.Edges, edges) {
+		t.Errorf("Expected the edges %+v, got %+v", edges, doc.Graph.Edges)
+	}
+}
+
+func TestWriteGEXF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportGraph().WriteGEXF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc gexf
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Expected valid xml, got %q", err)
+	}
+	nodes := []gexfNode{
+		{"0", "http://golang.org/", []gexfAttValue{{"kind", "internal"}, {"status", "200"}, {"distance", "0"}, {"content_type", "text/html"}}},
+		{"1", "http://golang.org/cmd/", []gexfAttValue{{"kind", "internal"}, {"status", "200"}, {"distance", "1"}, {"content_type", "text/html"}}},
+		{"2", "https://github.com/golang/go", []gexfAttValue{{"kind", "external"}, {"status", "0"}, {"distance", "2"}, {"content_type", ""}}},
+	}
+	if !reflect.DeepEqual(doc.Graph.Nodes, nodes) {
+		t.Errorf("Expected the nodes %+v, got %+v", nodes, doc.Graph.Nodes)
+	}
+	edges := []gexfEdge{
+		{"0", "1", "0", 2, []gexfAttValue{{"kind", "internal"}}},
+		{"1", "1", "2", 1, []gexfAttValue{{"kind", "external"}}},
+	}
+	if !reflect.DeepEqual(doc.Graph.Edges, edges) {
+		t.Errorf("Expected the edges %+v, got %+v", edges, doc.Graph.Edges)
+	}
+}
@@ -0,0 +1,234 @@
+package geomi
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// externalCheck is the result of checking an external link. done is closed once
+// the check has finished; any other checks of the same url wait on it.
+type externalCheck struct {
+	done chan struct{}
+	r    ResponseInfo
+}
+
+// externalChecker checks the external links of a crawl with a fixed number of
+// workers. The urls are queued as they are found; queueing never blocks, so a
+// slow or rate limited host only delays the other external checks, not the
+// crawl.
+type externalChecker struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []*url.URL // the urls waiting to be checked
+	closed  bool       // no more urls will be queued
+	wg      sync.WaitGroup
+}
+
+// newExternalChecker starts the Spider's external link check workers.
+func (s *Spider) newExternalChecker() *externalChecker {
+	n := s.Config.ExternalWorkers
+	if n < 1 {
+		n = 1
+	}
+	c := &externalChecker{}
+	c.cond = sync.NewCond(&c.mu)
+	c.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer c.wg.Done()
+			for {
+				u, ok := c.next()
+				if !ok {
+					return
+				}
+				s.fetchExternalLink(u)
+			}
+		}()
+	}
+	return c
+}
+
+// next waits for a queued url. It returns false once the checker is closed and
+// the queue is empty.
+func (c *externalChecker) next() (*url.URL, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.pending) == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if len(c.pending) == 0 {
+		return nil, false
+	}
+	u := c.pending[0]
+	c.pending[0] = nil
+	c.pending = c.pending[1:]
+	return u, true
+}
+
+// check queues the url to be checked.
+func (c *externalChecker) check(u *url.URL) {
+	c.mu.Lock()
+	c.pending = append(c.pending, u)
+	c.mu.Unlock()
+	c.cond.Signal()
+}
+
+// close waits for the queued checks to finish. If stop is true, the checks that
+// haven't started are dropped instead; the ones running are waited for.
+func (c *externalChecker) close(stop bool) {
+	c.mu.Lock()
+	c.closed = true
+	if stop {
+		c.pending = nil
+	}
+	c.mu.Unlock()
+	c.cond.Broadcast()
+	c.wg.Wait()
+}
+
+// hostLimiter limits the concurrency and rate of requests to an external host.
+type hostLimiter struct {
+	sem      chan struct{}
+	interval time.Duration
+	next     chan time.Time // holds the earliest time the next request may start
+}
+
+func newHostLimiter(concurrency int, interval time.Duration) *hostLimiter {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	l := &hostLimiter{
+		sem:      make(chan struct{}, concurrency),
+		interval: interval,
+		next:     make(chan time.Time, 1),
+	}
+	l.next <- time.Time{}
+	return l
+}
+
+// acquire blocks until a request to the host is allowed.
+func (l *hostLimiter) acquire() {
+	l.sem <- struct{}{}
+	next := <-l.next
+	now := time.Now()
+	if now.Before(next) {
+		time.Sleep(next.Sub(now))
+		now = next
+	}
+	l.next <- now.Add(l.interval)
+}
+
+// release frees the slot taken by acquire.
+func (l *hostLimiter) release() {
+	<-l.sem
+}
+
+// normalizeURL returns the url in a form that is suitable for use as a cache key:
+// the scheme and host are lower cased, default ports and the fragment are
+// removed, and an empty path becomes /.
+func normalizeURL(u *url.URL) string {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	if (n.Scheme == "http" && strings.HasSuffix(n.Host, ":80")) || (n.Scheme == "https" && strings.HasSuffix(n.Host, ":443")) {
+		n.Host = n.Host[:strings.LastIndex(n.Host, ":")]
+	}
+	if n.Path == "" {
+		n.Path = "/"
+	}
+	n.Fragment = ""
+	n.RawFragment = ""
+	return n.String()
+}
+
+// fetchExternalLink checks an external link's status. The result is cached by
+// normalized url, so a link is only checked once no matter how many forms of it
+// are found. Note, this does not implement fetcher.
+func (s *Spider) fetchExternalLink(u *url.URL) {
+	key := normalizeURL(u)
+	s.Lock()
+	c, ok := s.checks[key]
+	if !ok {
+		c = &externalCheck{done: make(chan struct{})}
+		s.checks[key] = c
+	}
+	lim, ok2 := s.hostLimits[u.Host]
+	if !ok2 {
+		lim = newHostLimiter(s.Config.ExternalHostConcurrency, s.Config.ExternalHostInterval)
+		s.hostLimits[u.Host] = lim
+	}
+	s.Unlock()
+	if ok {
+		<-c.done
+	} else {
+		c.r = s.checkExternalLink(u, lim)
+		close(c.done)
+	}
+	s.Lock()
+	s.externalLinks[u.String()] = c.r
+	s.Unlock()
+}
+
+// checkExternalLink requests the url's HEAD, falling back to a GET if the server
+// doesn't support HEAD, and retries according to the ExternalRetry policy.
+func (s *Spider) checkExternalLink(u *url.URL, lim *hostLimiter) ResponseInfo {
+	client := &http.Client{Timeout: s.Config.ExternalTimeout}
+	policy := s.Config.ExternalRetry
+	request := func(method string) ResponseInfo {
+		return s.exchange("external", method, u.String(), func() Result {
+			return Result{ResponseInfo: s.requestExternal(client, lim, method, u)}
+		}).ResponseInfo
+	}
+	var attempts []Attempt
+	for i := 1; ; i++ {
+		r := request("HEAD")
+		if s.Config.ExternalHeadFallback && headNotSupported(r.StatusCode) {
+			attempts = append(attempts, attempt(r))
+			r = request("GET")
+		}
+		attempts = append(attempts, attempt(r))
+		if i >= policy.attempts() || !policy.retryable(r) {
+			r.Attempts = attempts
+			return r
+		}
+		wait := policy.delay(i, r)
+		attempts[len(attempts)-1].Wait = wait
+		time.Sleep(wait)
+	}
+}
+
+// headNotSupported returns whether the status is one that servers commonly use
+// when they don't allow HEAD requests.
+func headNotSupported(code int) bool {
+	return code == http.StatusMethodNotAllowed || code == http.StatusForbidden || code == http.StatusNotImplemented
+}
+
+// requestExternal makes a single request to an external url, within the host's
+// limits. The body, if any, is not read.
+func (s *Spider) requestExternal(client *http.Client, lim *hostLimiter, method string, u *url.URL) ResponseInfo {
+	var r ResponseInfo
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	req.Header.Set("User-Agent", s.Config.UserAgent)
+	lim.acquire()
+	start := time.Now()
+	resp, err := redirectClient(client, &r).Do(req)
+	r.Duration = time.Since(start)
+	lim.release()
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	resp.Body.Close()
+	r.Status = resp.Status
+	r.StatusCode = resp.StatusCode
+	r.ContentType = resp.Header.Get("Content-Type")
+	r.Header = resp.Header
+	return r
+}
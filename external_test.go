@@ -0,0 +1,236 @@
+package geomi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		u        string
+		expected string
+	}{
+		{"http://golang.org", "http://golang.org/"},
+		{"HTTP://GoLang.org:80/pkg/", "http://golang.org/pkg/"},
+		{"https://golang.org:443/pkg/#fmt", "https://golang.org/pkg/"},
+		{"https://golang.org:8443/pkg/?q=1", "https://golang.org:8443/pkg/?q=1"},
+		{"http://golang.org/Pkg/", "http://golang.org/Pkg/"},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.u)
+		if n := normalizeURL(u); n != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.u, test.expected, n)
+		}
+	}
+}
+
+// externalSpider returns a spider configured to check external links quickly.
+func externalSpider() *Spider {
+	s, _ := NewSpider("http://golang.org/")
+	s.Config.ExternalHostInterval = 0
+	s.Config.ExternalRetry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryStatusCodes: DefaultRetryStatusCodes, RespectRetryAfter: true}
+	return s
+}
+
+func TestFetchExternalLink(t *testing.T) {
+	var flaky, requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/nohead":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/flaky":
+			if atomic.AddInt32(&flaky, 1) < 3 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+	tests := []struct {
+		path     string
+		fallback bool
+		expected int
+	}{
+		{"/", true, 200},
+		{"/nohead", true, 200},
+		{"/nohead", false, 405},
+		{"/flaky", true, 200},
+		{"/down", true, 502},
+	}
+	for _, test := range tests {
+		s := externalSpider()
+		s.Config.ExternalHeadFallback = test.fallback
+		u, _ := url.Parse(ts.URL + test.path)
+		s.fetchExternalLink(u)
+		r := s.externalLinks[u.String()]
+		if r.StatusCode != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.expected, r.StatusCode)
+		}
+	}
+	// the same link, in different forms, is only checked once
+	s := externalSpider()
+	atomic.StoreInt32(&requests, 0)
+	for _, v := range []string{ts.URL, ts.URL + "/", ts.URL + "/#top"} {
+		u, _ := url.Parse(v)
+		s.fetchExternalLink(u)
+		if s.externalLinks[v].StatusCode != 200 {
+			t.Errorf("%s: expected status 200, got %d", v, s.externalLinks[v].StatusCode)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("Expected 1 request, got %d", n)
+	}
+}
+
+func TestExternalHostConcurrency(t *testing.T) {
+	var inFlight, max int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+	}))
+	defer ts.Close()
+	s := externalSpider()
+	s.Config.ExternalHostConcurrency = 2
+	var wg sync.WaitGroup
+	for _, p := range []string{"/a", "/b", "/c", "/d", "/e", "/f"} {
+		u, _ := url.Parse(ts.URL + p)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.fetchExternalLink(u)
+		}()
+	}
+	wg.Wait()
+	if max > 2 {
+		t.Errorf("Expected at most 2 concurrent requests, got %d", max)
+	}
+	if len(s.externalLinks) != 6 {
+		t.Errorf("Expected 6 checked links, got %d", len(s.externalLinks))
+	}
+}
+
+func TestExternalWorkers(t *testing.T) {
+	var inFlight, max int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+	}))
+	defer ts.Close()
+	var urls []string
+	for _, p := range []string{"/a", "/b", "/c", "/d", "/e", "/f"} {
+		urls = append(urls, ts.URL+p)
+	}
+	f := statusFetcher{
+		"http://golang.org/": {r: ResponseInfo{Status: "200 OK", StatusCode: 200, ContentType: "text/html"}, urls: urls},
+	}
+	s := crawledSpider(t, "http://golang.org/", AdaptFetcher(f), func(c *Config) {
+		c.CheckExternalLinks = true
+		c.ExternalHostConcurrency = 6
+		c.ExternalHostInterval = 0
+		c.ExternalWorkers = 2
+	})
+	if max > 2 {
+		t.Errorf("Expected at most 2 concurrent checks, got %d", max)
+	}
+	// every queued check was run
+	for _, u := range urls {
+		if r := s.externalLinks[u]; r.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected the link to be checked, got %+v", u, r)
+		}
+	}
+}
+
+func TestExternalChecksStopped(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer ts.Close()
+	f := statusFetcher{
+		"http://golang.org/": {
+			r:    ResponseInfo{Status: "200 OK", StatusCode: 200, ContentType: "text/html"},
+			urls: []string{ts.URL + "/a", ts.URL + "/b", "http://golang.org/next"},
+		},
+	}
+	s := crawledSpider(t, "http://golang.org/", AdaptFetcher(f), func(c *Config) {
+		c.CheckExternalLinks = true
+		c.ExternalHostInterval = 0
+		c.ExternalWorkers = 1
+		c.MaxPages = 1
+	})
+	if s.stopReason != StopMaxPages {
+		t.Fatalf("Expected the crawl to be stopped by %q, got %q", StopMaxPages, s.stopReason)
+	}
+	// the checks that hadn't started when the crawl was stopped were dropped;
+	// with one worker, at most the first had
+	if n := atomic.LoadInt32(&requests); n > 1 {
+		t.Errorf("Expected at most 1 external check, got %d", n)
+	}
+}
+
+func TestExternalChecksDontBlockCrawl(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	var urls []string
+	for i := 0; i < 20; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%d", ts.URL, i))
+	}
+	// the internal page is found after the external links
+	urls = append(urls, "http://golang.org/next")
+	html := ResponseInfo{Status: "200 OK", StatusCode: 200, ContentType: "text/html"}
+	var mu sync.Mutex
+	fetched := make(map[string]time.Time)
+	f := RequestFetcherFunc(func(req Request) Result {
+		mu.Lock()
+		fetched[req.URL] = time.Now()
+		mu.Unlock()
+		if req.URL == "http://golang.org/" {
+			return Result{ResponseInfo: html, URLs: urls}
+		}
+		return Result{ResponseInfo: html}
+	})
+	interval := 25 * time.Millisecond
+	start := time.Now()
+	s := crawledSpider(t, "http://golang.org/", f, func(c *Config) {
+		c.CheckExternalLinks = true
+		c.ExternalHostConcurrency = 1
+		c.ExternalHostInterval = interval
+		c.ExternalWorkers = 1
+	})
+	// the checks are paced, but the crawl doesn't wait on them
+	if d := fetched["http://golang.org/next"].Sub(fetched["http://golang.org/"]); d >= 10*interval {
+		t.Errorf("Expected the internal fetch not to wait for the external checks, it was delayed by %s", d)
+	}
+	if d := time.Since(start); d < 19*interval {
+		t.Errorf("Expected the external checks to be paced, they took %s", d)
+	}
+	if len(s.externalLinks) != 20 {
+		t.Errorf("Expected 20 checked links, got %d", len(s.externalLinks))
+	}
+}
@@ -0,0 +1,243 @@
+package geomi
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// LinkExtractor finds the links in a body of a specific media type. Links are
+// resolved against base.
+type LinkExtractor interface {
+	ExtractLinks(base *url.URL, body io.Reader) ([]string, error)
+}
+
+// LinkExtractorFunc is a function that implements LinkExtractor.
+type LinkExtractorFunc func(base *url.URL, body io.Reader) ([]string, error)
+
+// ExtractLinks calls f.
+func (f LinkExtractorFunc) ExtractLinks(base *url.URL, body io.Reader) ([]string, error) {
+	return f(base, body)
+}
+
+// The link extractors that come with geomi.
+var (
+	HTMLLinks    LinkExtractor = LinkExtractorFunc(linksFromReader) // a elements, stylesheets and feeds
+	CSSLinks     LinkExtractor = LinkExtractorFunc(cssLinks)        // url() and @import
+	FeedLinks    LinkExtractor = LinkExtractorFunc(feedLinks)       // RSS and Atom links and enclosures
+	SitemapLinks LinkExtractor = LinkExtractorFunc(sitemapLinks)    // sitemap and sitemap index locations
+	XMLLinks     LinkExtractor = LinkExtractorFunc(xmlLinks)        // feeds or sitemaps, depending on the root element
+	TextLinks    LinkExtractor = LinkExtractorFunc(textLinks)       // http and https urls in plain text
+)
+
+// defaultExtractors are the link extractors used when a media type doesn't have
+// a handler or extractor registered.
+var defaultExtractors = map[string]LinkExtractor{
+	"text/html":             HTMLLinks,
+	"application/xhtml+xml": HTMLLinks,
+	"text/css":              CSSLinks,
+	"application/rss+xml":   FeedLinks,
+	"application/atom+xml":  FeedLinks,
+	"application/xml":       XMLLinks,
+	"text/xml":              XMLLinks,
+	"text/plain":            TextLinks,
+}
+
+// RegisterLinkExtractor registers the link extractor for the media type. The body
+// of responses of that type is kept and the extractor finds its links. Content
+// handlers registered for the exact media type take precedence.
+func (s *Site) RegisterLinkExtractor(mediaType string, e LinkExtractor) {
+	if s.extractors == nil {
+		s.extractors = make(map[string]LinkExtractor)
+	}
+	s.extractors[strings.ToLower(mediaType)] = e
+}
+
+// RegisterLinkExtractor registers the link extractor for the media type with the
+// Site used by Crawl. See Site.RegisterLinkExtractor.
+func (s *Spider) RegisterLinkExtractor(mediaType string, e LinkExtractor) {
+	if s.extractors == nil {
+		s.extractors = make(map[string]LinkExtractor)
+	}
+	s.extractors[strings.ToLower(mediaType)] = e
+}
+
+// resolve resolves the references against base, skipping any that can't be
+// parsed, are empty, or are data urls.
+func resolve(base *url.URL, refs []string) []string {
+	var links []string
+	for _, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(strings.ToLower(ref), "data:") {
+			continue
+		}
+		u, err := url.Parse(ref)
+		if err != nil {
+			continue
+		}
+		links = append(links, base.ResolveReference(u).String())
+	}
+	return links
+}
+
+var (
+	cssComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssURL     = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]*))\s*\)`)
+	cssImport  = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// cssLinks returns the urls referenced by url() and @import in a stylesheet.
+func cssLinks(base *url.URL, body io.Reader) ([]string, error) {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	b = cssComment.ReplaceAll(b, nil)
+	var refs []string
+	// @import url(...) is found by the url() match
+	for _, re := range []*regexp.Regexp{cssImport, cssURL} {
+		for _, m := range re.FindAllSubmatch(b, -1) {
+			refs = append(refs, string(bytes.Join(m[1:], nil)))
+		}
+	}
+	return resolve(base, refs), nil
+}
+
+// xml document kinds.
+const (
+	xmlUnknown = iota
+	xmlFeed
+	xmlSitemap
+)
+
+// feedLinks returns the links in an RSS or Atom feed.
+func feedLinks(base *url.URL, body io.Reader) ([]string, error) {
+	return linksFromXML(base, body, xmlFeed)
+}
+
+// sitemapLinks returns the locations in a sitemap or sitemap index.
+func sitemapLinks(base *url.URL, body io.Reader) ([]string, error) {
+	return linksFromXML(base, body, xmlSitemap)
+}
+
+// xmlLinks returns the links in a feed or sitemap; other xml has no links.
+func xmlLinks(base *url.URL, body io.Reader) ([]string, error) {
+	return linksFromXML(base, body, xmlUnknown)
+}
+
+// linksFromXML walks the xml for links. If the kind is unknown, it is decided by
+// the root element.
+//   - feeds: RSS link, comments, and enclosure url; Atom link href and
+//     content src
+//   - sitemaps: loc
+func linksFromXML(base *url.URL, body io.Reader, kind int) ([]string, error) {
+	d := xml.NewDecoder(body)
+	d.Strict = false
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		r, err := charset.NewReaderLabel(label, input)
+		if err != nil {
+			// good enough for finding urls, which are mostly ascii
+			return input, nil
+		}
+		return r, nil
+	}
+	var refs []string
+	var text *strings.Builder // set while inside an element whose text is a link
+	root := true
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return resolve(base, refs), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			if root {
+				root = false
+				if kind == xmlUnknown {
+					switch name {
+					case "rss", "RDF", "feed":
+						kind = xmlFeed
+					case "urlset", "sitemapindex":
+						kind = xmlSitemap
+					default:
+						return nil, nil
+					}
+				}
+			}
+			switch kind {
+			case xmlFeed:
+				switch name {
+				case "link":
+					if href, ok := xmlAttr(t, "href"); ok {
+						refs = append(refs, href)
+						continue
+					}
+					text = &strings.Builder{}
+				case "comments":
+					text = &strings.Builder{}
+				case "enclosure":
+					if v, ok := xmlAttr(t, "url"); ok {
+						refs = append(refs, v)
+					}
+				case "content":
+					if v, ok := xmlAttr(t, "src"); ok {
+						refs = append(refs, v)
+					}
+				}
+			case xmlSitemap:
+				if name == "loc" {
+					text = &strings.Builder{}
+				}
+			}
+		case xml.CharData:
+			if text != nil {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if text != nil {
+				refs = append(refs, text.String())
+				text = nil
+			}
+		}
+	}
+}
+
+// xmlAttr returns the value of the element's attribute.
+func xmlAttr(e xml.StartElement, name string) (string, bool) {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+var textURL = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+
+// textLinks returns the http and https urls in plain text. Trailing punctuation
+// is assumed to be part of the text, not the url.
+func textLinks(base *url.URL, body io.Reader) ([]string, error) {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for _, m := range textURL.FindAll(b, -1) {
+		u := strings.TrimRight(string(m), ".,;:!?")
+		// a closing paren is only part of the url if it opened one
+		for strings.HasSuffix(u, ")") && strings.Count(u, "(") < strings.Count(u, ")") {
+			u = strings.TrimRight(u[:len(u)-1], ".,;:!?")
+		}
+		refs = append(refs, u)
+	}
+	return resolve(base, refs), nil
+}
@@ -0,0 +1,91 @@
+package geomi
+
+import (
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLinkExtractors(t *testing.T) {
+	base, _ := url.Parse("http://golang.org/doc/")
+	tests := []struct {
+		name     string
+		e        LinkExtractor
+		body     string
+		expected []string
+	}{
+		{"html", HTMLLinks, `<link rel="stylesheet" href="/s.css"><link rel="alternate" type="application/rss+xml" href="feed.xml">
+<link rel="icon" href="/favicon.ico"><link rel="alternate" hreflang="ko" href="/ko/"><a href="a.html">a</a>`,
+			[]string{"http://golang.org/s.css", "http://golang.org/doc/feed.xml", "http://golang.org/doc/a.html"}},
+		{"css", CSSLinks, `@import "base.css"; @import url('print.css') print;
+/* url(commented.png) */
+body { background: url( "img/bg.png" ) }
+.logo { background-image: url(/logo.svg), url(data:image/png;base64,AAAA); }`,
+			[]string{"http://golang.org/doc/base.css", "http://golang.org/doc/print.css", "http://golang.org/doc/img/bg.png", "http://golang.org/logo.svg"}},
+		{"rss", FeedLinks, `<?xml version="1.0"?><rss version="2.0"><channel><title>Go</title><link>http://golang.org/</link>
+<item><title>Post</title><link>/blog/post</link><comments>/blog/post#comments</comments><enclosure url="/pod.mp3" type="audio/mpeg"/></item></channel></rss>`,
+			[]string{"http://golang.org/", "http://golang.org/blog/post", "http://golang.org/blog/post#comments", "http://golang.org/pod.mp3"}},
+		{"atom", FeedLinks, `<?xml version="1.0" encoding="utf-8"?><feed xmlns="http://www.w3.org/2005/Atom"><link href="http://golang.org/feed.atom" rel="self"/>
+<entry><link href="/blog/a"/><content type="html" src="/blog/a.html"/></entry></feed>`,
+			[]string{"http://golang.org/feed.atom", "http://golang.org/blog/a", "http://golang.org/blog/a.html"}},
+		{"sitemap", SitemapLinks, `<?xml version="1.0" encoding="UTF-8"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc> http://golang.org/pkg/ </loc><lastmod>2015-06-01</lastmod></url><url><loc>http://golang.org/cmd/</loc></url></urlset>`,
+			[]string{"http://golang.org/pkg/", "http://golang.org/cmd/"}},
+		{"xml sitemap index", XMLLinks, `<sitemapindex><sitemap><loc>/sitemap1.xml</loc></sitemap></sitemapindex>`,
+			[]string{"http://golang.org/sitemap1.xml"}},
+		{"xml feed", XMLLinks, `<rss><channel><link>/</link></channel></rss>`, []string{"http://golang.org/"}},
+		{"xml other", XMLLinks, `<config><link>/nope</link></config>`, nil},
+		{"text", TextLinks, "See https://golang.org/pkg/fmt/, and (http://golang.org/cmd/).\nAlso http://en.wikipedia.org/wiki/Go_(programming_language).",
+			[]string{"https://golang.org/pkg/fmt/", "http://golang.org/cmd/", "http://en.wikipedia.org/wiki/Go_(programming_language)"}},
+	}
+	for _, test := range tests {
+		links, err := test.e.ExtractLinks(base, strings.NewReader(test.body))
+		if err != nil {
+			t.Errorf("%s: expected no error, got %q", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(links, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, links)
+		}
+	}
+}
+
+func TestSiteHandlerPrecedence(t *testing.T) {
+	extractor := LinkExtractorFunc(func(base *url.URL, body io.Reader) ([]string, error) {
+		return []string{"extractor"}, nil
+	})
+	handler := ContentHandlerFunc(func(base *url.URL, body io.Reader, r *ResponseInfo) (string, []string, error) {
+		return "", []string{"handler"}, nil
+	})
+	base, _ := url.Parse("http://golang.org/")
+	tests := []struct {
+		register func(s *Site)
+		expected []string
+	}{
+		{func(s *Site) {}, []string{"http://golang.org/a"}},
+		{func(s *Site) { s.RegisterLinkExtractor("text/css", extractor) }, []string{"extractor"}},
+		{func(s *Site) { s.HandleContent("text/*", handler) }, []string{"handler"}},
+		{func(s *Site) {
+			s.HandleContent("text/*", handler)
+			s.RegisterLinkExtractor("text/css", extractor)
+		}, []string{"extractor"}},
+		{func(s *Site) {
+			s.HandleContent("text/css", handler)
+			s.RegisterLinkExtractor("text/css", extractor)
+		}, []string{"handler"}},
+	}
+	for i, test := range tests {
+		s := &Site{}
+		test.register(s)
+		_, links, err := s.handler("text/css").Handle(base, strings.NewReader("a { background: url(a) }"), &ResponseInfo{})
+		if err != nil {
+			t.Errorf("%d: expected no error, got %q", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(links, test.expected) {
+			t.Errorf("%d: expected %v, got %v", i, test.expected, links)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package geomi
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// BrokenFragment is a link to a fragment, e.g. /doc/#install, that doesn't exist
+// on the page it links to.
+type BrokenFragment struct {
+	Page     string // the page with the link
+	URL      string // the link, including the fragment
+	Fragment string
+	Text     string // the link's anchor text
+}
+
+// extractAnchors returns the ids, and the names of a elements, in the html read
+// from r, sorted and deduplicated. These are what a fragment can refer to.
+func extractAnchors(r io.Reader) []string {
+	return scanHTML(&url.URL{}, r, false).anchors
+}
+
+// HasAnchor returns whether the page has an element that the fragment refers to.
+func (p Page) HasAnchor(fragment string) bool {
+	i := sort.SearchStrings(p.Anchors, fragment)
+	return i < len(p.Anchors) && p.Anchors[i] == fragment
+}
+
+// stripFragment returns the url without its fragment.
+func stripFragment(u string) string {
+	if i := strings.IndexByte(u, '#'); i >= 0 {
+		return u[:i]
+	}
+	return u
+}
+
+// checkedFragment returns whether the fragment should refer to an anchor. Empty
+// fragments and top go to the top of the page, fragments starting with ! are
+// hashbang routes, and :~: starts a text fragment; none of those refer to an
+// element.
+func checkedFragment(f string) bool {
+	return f != "" && !strings.EqualFold(f, "top") && !strings.HasPrefix(f, "!") && !strings.HasPrefix(f, ":~:")
+}
+
+// brokenFragments returns the page's links to fragments that don't exist. Only
+// links to pages that were crawled and are html can be checked.
+func brokenFragments(p Page, pages map[string]Page, responses map[string]ResponseInfo) []BrokenFragment {
+	var broken []BrokenFragment
+	for _, l := range p.Links {
+		u, err := url.Parse(l.URL)
+		if err != nil || !checkedFragment(u.Fragment) {
+			continue
+		}
+		target := stripFragment(l.URL)
+		tp, ok := pages[target]
+		if !ok || !isHTML(mediaType(responses[target].ContentType)) {
+			continue
+		}
+		if !tp.HasAnchor(u.Fragment) {
+			broken = append(broken, BrokenFragment{Page: p.URL.String(), URL: l.URL, Fragment: u.Fragment, Text: l.Text})
+		}
+	}
+	return broken
+}
+
+// BrokenFragments returns the links, on every crawled page, to fragments that
+// don't exist on the linked page, sorted by page and then by their position on
+// the page.
+func (s *Spider) BrokenFragments() []BrokenFragment {
+	s.Lock()
+	defer s.Unlock()
+	keys := make([]string, 0, len(s.Pages))
+	for k := range s.Pages {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var broken []BrokenFragment
+	for _, k := range keys {
+		broken = append(broken, brokenFragments(s.Pages[k], s.Pages, s.fetchedURLs)...)
+	}
+	return broken
+}
+
+// BrokenFragmentRule finds links to fragments that don't exist on the linked
+// page.
+type BrokenFragmentRule struct{}
+
+func (BrokenFragmentRule) Name() string { return "broken-fragment" }
+
+func (r BrokenFragmentRule) Check(c *AuditContext, p Page) []Issue {
+	var issues []Issue
+	for _, b := range brokenFragments(p, c.Pages, c.Responses) {
+		issues = append(issues, Issue{r.Name(), SeverityError, fmt.Sprintf("link to %s: there is no element with the id %q", b.URL, b.Fragment)})
+	}
+	return issues
+}
@@ -0,0 +1,71 @@
+package geomi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractAnchors(t *testing.T) {
+	doc := `<h1 id="intro">Intro</h1><a name="old"></a><div name="ignored" id="b"><p id="intro">again</p></div>`
+	expected := []string{"b", "intro", "old"}
+	anchors := extractAnchors(strings.NewReader(doc))
+	if !reflect.DeepEqual(anchors, expected) {
+		t.Errorf("Expected %v, got %v", expected, anchors)
+	}
+	p := Page{Anchors: anchors}
+	if !p.HasAnchor("old") || p.HasAnchor("ignored") || p.HasAnchor("Intro") {
+		t.Errorf("Expected old to be an anchor, and ignored and Intro not to be")
+	}
+}
+
+func TestStripFragment(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"http://golang.org/doc/", "http://golang.org/doc/"},
+		{"http://golang.org/doc/#install", "http://golang.org/doc/"},
+		{"http://golang.org/doc/?q=1#", "http://golang.org/doc/?q=1"},
+	}
+	for _, test := range tests {
+		if u := stripFragment(test.url); u != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.url, test.expected, u)
+		}
+	}
+}
+
+func TestBrokenFragments(t *testing.T) {
+	html := ResponseInfo{StatusCode: 200, ContentType: "text/html"}
+	f := statusFetcher{
+		"http://golang.org/doc/": {
+			`<h2 id="install">Install</h2><a href="#install">ok</a><a href="#missing">missing</a><a href="#top">top</a><a href="#!/route">route</a>
+<a href="a.html#section">ok</a><a href="a.html#gone">gone</a><a href="b.txt#x">not html</a><a href="/pkg/#x">not crawled</a>`,
+			html,
+			[]string{"http://golang.org/doc/a.html#section", "http://golang.org/doc/a.html#gone", "http://golang.org/doc/b.txt#x", "http://golang.org/pkg/#x"},
+		},
+		"http://golang.org/doc/a.html": {`<section id="section"><a href="/doc/#install">back</a></section>`, html, []string{"http://golang.org/doc/#install"}},
+		"http://golang.org/doc/b.txt":  {"text", ResponseInfo{StatusCode: 200, ContentType: "text/plain"}, nil},
+	}
+	s := crawledSpider(t, "http://golang.org/doc/", AdaptFetcher(f))
+	// the fragments aren't part of what's crawled
+	for k := range s.Pages {
+		if strings.Contains(k, "#") {
+			t.Errorf("Expected the fragment to be stripped from the crawled url, got %s", k)
+		}
+	}
+	if links := s.Pages["http://golang.org/doc/"].links; links[0] != "http://golang.org/doc/a.html" {
+		t.Errorf("Expected the fragment to be stripped from the link, got %s", links[0])
+	}
+	expected := []BrokenFragment{
+		{"http://golang.org/doc/", "http://golang.org/doc/#missing", "missing", "missing"},
+		{"http://golang.org/doc/", "http://golang.org/doc/a.html#gone", "gone", "gone"},
+	}
+	if broken := s.BrokenFragments(); !reflect.DeepEqual(broken, expected) {
+		t.Errorf("Expected %v, got %v", expected, broken)
+	}
+	issues := s.Audit(BrokenFragmentRule{})
+	if len(issues) != 1 || len(issues["http://golang.org/doc/"]) != 2 {
+		t.Errorf("Expected 2 issues for /doc/, got %v", issues)
+	}
+}
@@ -9,11 +9,12 @@
 //   * respects ROBOTS.txt TODO
 //   * configurable concurrent walkers TODO
 //   * configurable wait interval range TODO
-//   * configurable max requests per: TODO
+//   * configurable max requests per:
 //     * min
 //     * hour
 //     * day
-//     * month
+//     * month TODO
+//   * configurable max pages, bytes downloaded, and crawl duration
 //
 // To start, go get the geomi package:
 //    go get github.com/mohae/geomi
@@ -30,6 +31,7 @@
 package geomi
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
@@ -49,15 +51,25 @@ import (
 
 // Defaults
 var (
-	DefaultFetchInterval  time.Duration = time.Second                                                                                            // default min. time between fetches
-	DefaultJitter         time.Duration = time.Second                                                                                            // default max additional, random, fetch delay
-	DefaultRobotUserAgent string        = "Googlebot (geomi)"                                                                                    // default user agent identifier for the bot.
-	DefaultUserAgent      string        = "Mozilla/5.0 (Windows NT 6.1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2228.0 Safari/537.36" // the default user agent
+	DefaultExternalHostConcurrency int           = 2                                                                                                      // default max concurrent checks per external host
+	DefaultExternalHostInterval    time.Duration = 500 * time.Millisecond                                                                                 // default min. time between requests to an external host
+	DefaultExternalTimeout         time.Duration = 30 * time.Second                                                                                       // default max time for an external link check
+	DefaultExternalWorkers         int           = 8                                                                                                      // default number of external links checked at the same time
+	DefaultFetchInterval           time.Duration = time.Second                                                                                            // default min. time between fetches
+	DefaultJitter                  time.Duration = time.Second                                                                                            // default max additional, random, fetch delay
+	DefaultMaxBodySize             int64         = 10 << 20                                                                                               // default max number of bytes of a body that are read
+	DefaultMaxFetchInterval        time.Duration = 30 * time.Second                                                                                       // default max time between fetches when throttling adaptively
+	DefaultMinFetchInterval        time.Duration = 500 * time.Millisecond                                                                                 // default min time between fetches when throttling adaptively
+	DefaultNetworkIdle             time.Duration = 500 * time.Millisecond                                                                                 // default time the network must be idle before a rendered page is read
+	DefaultRenderTimeout           time.Duration = 30 * time.Second                                                                                       // default max time rendering a page may take
+	DefaultRobotUserAgent          string        = "Googlebot (geomi)"                                                                                    // default user agent identifier for the bot.
+	DefaultUserAgent               string        = "Mozilla/5.0 (Windows NT 6.1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2228.0 Safari/537.36" // the default user agent
+	DefaultWARCMaxSize             int64         = 1 << 30                                                                                                // default size at which a WARC file is rotated
 )
 
-// Fetcher is an interface that makes it easier to test. In the future, it may be
-// useful for other purposes, but that would require exporting the method that uses
-// it first.
+// Fetcher is the simplest way to fetch the urls of a crawl; it is only given the
+// url. Use AdaptFetcher to crawl with one, or implement RequestFetcher to be
+// given the rest of the Request.
 type Fetcher interface {
 	// Fetch returns the body of URL and
 	// a slice of URLs found on that page
@@ -65,25 +77,81 @@ type Fetcher interface {
 }
 
 type Config struct {
-	CheckExternalLinks bool          // Whether a HEAD should be performed on external links
-	FetchInterval      time.Duration // The minimum time between fetching URLS
-	Jitter             time.Duration // The max amount of jitter to add to the FetchInterval, the actual jitter is random.
-	RespectRobots      bool          // Whether the robots.txt should be respected
-	RestrictToScheme   bool          // Whether the crawl should be restricted to the base URL's scheme
-	RobotUserAgent     string        // The user agent for the robot
-	UserAgent          string        // The user agent to use.
+	AdaptiveThrottle        bool          // Whether the fetch interval adapts to the server's response times and errors
+	BodyDir                 string        // The directory bodies are written to when BodyStorage is BodyOnDisk; if empty, a temp dir is used, see RemoveBodies.
+	BodyStorage             BodyStorage   // How the bodies of fetched pages are kept
+	BrowserPath             string        // The Chromium executable that is started to render pages when BrowserURL isn't set; if empty, one is looked for in the PATH.
+	BrowserURL              string        // The DevTools endpoint of a running headless browser used to render pages, e.g. http://127.0.0.1:9222
+	CheckAccessibility      bool          // Whether html pages are checked for accessibility issues
+	CheckExternalLinks      bool          // Whether a HEAD should be performed on external links
+	DownloadNonHTML         bool          // Whether the bodies of responses that aren't html, and have no content handler, are downloaded
+	ExtractContent          bool          // Whether the title, headings, and other content are extracted from html pages
+	ExternalHeadFallback    bool          // Whether an external link is checked with a GET when its HEAD returns 403, 405, or 501
+	ExternalHostConcurrency int           // The max number of concurrent checks per external host; < 1 is treated as 1.
+	ExternalHostInterval    time.Duration // The minimum time between requests to the same external host
+	ExternalRetry           RetryPolicy   // How failed external link checks are retried
+	ExternalTimeout         time.Duration // The max time an external link check may take; 0 means no limit.
+	ExternalWorkers         int           // The number of external links checked at the same time, across all hosts; < 1 is treated as 1.
+	FetchInterval           time.Duration // The minimum time between fetching URLS
+	Jitter                  time.Duration // The max amount of jitter to add to the FetchInterval, the actual jitter is random.
+	LinkCheck               bool          // Whether the pages linking to each url are recorded, for broken link reports; implies CheckExternalLinks.
+	MaxBytes                int64         // The max number of body bytes to download; 0 means no limit.
+	MaxBodySize             int64         // The max number of bytes of a body that are read; 0 means no limit.
+	MaxDuration             time.Duration // The max time the crawl may run; 0 means no limit.
+	MaxFetchInterval        time.Duration // The max time between fetches when AdaptiveThrottle is set
+	MaxPages                int           // The max number of pages to fetch; 0 means no limit.
+	MaxRequestsPerDay       int           // The max number of requests per day; 0 means no limit.
+	MaxRequestsPerHour      int           // The max number of requests per hour; 0 means no limit.
+	MaxRequestsPerMinute    int           // The max number of requests per minute; 0 means no limit.
+	MinFetchInterval        time.Duration // The min time between fetches when AdaptiveThrottle is set
+	MirrorDir               string        // The directory the crawled pages are written to, with their links rewritten, for offline browsing; if empty, they aren't.
+	NetworkIdle             time.Duration // How long the network must be idle, after a rendered page has loaded, before it's read
+	RenderAll               bool          // Whether every page is rendered in a headless browser, for sites built by JavaScript
+	RenderPatterns          []string      // The regular expressions matching the urls of pages that are rendered in a headless browser
+	RenderTimeout           time.Duration // The max time rendering a page may take; 0 means no limit, though the wait for the network to be idle is at most a minute.
+	RespectRobots           bool          // Whether the robots.txt should be respected
+	RestrictToScheme        bool          // Whether the crawl should be restricted to the base URL's scheme
+	Retry                   RetryPolicy   // How failed fetches of pages are retried
+	RobotUserAgent          string        // The user agent for the robot
+	UserAgent               string        // The user agent to use.
+	ValidateMailto          bool          // Whether the addresses of mailto links are validated
+	WARCDir                 string        // The directory WARC files, of every fetch, are written to; if empty, none are written.
+	WARCMaxSize             int64         // The size at which a WARC file is closed and a new one is started; 0 means no limit.
+	WaitForBudget           bool          // Whether the crawl waits for the per minute/hour/day request limits to allow more requests, instead of stopping
 }
 
 // NewConfig returns a Config struct with Geomi defaults applied.
 func NewConfig() *Config {
 	return &Config{
-		CheckExternalLinks: true,
-		FetchInterval:      DefaultFetchInterval,
-		Jitter:             DefaultJitter,
-		RespectRobots:      true,
-		RestrictToScheme:   false,
-		RobotUserAgent:     DefaultRobotUserAgent,
-		UserAgent:          DefaultUserAgent,
+		AdaptiveThrottle:        false,
+		BodyStorage:             BodyInMemory,
+		CheckAccessibility:      false,
+		CheckExternalLinks:      true,
+		DownloadNonHTML:         true,
+		ExtractContent:          true,
+		ExternalHeadFallback:    true,
+		ExternalHostConcurrency: DefaultExternalHostConcurrency,
+		ExternalHostInterval:    DefaultExternalHostInterval,
+		ExternalRetry:           NewRetryPolicy(3),
+		ExternalTimeout:         DefaultExternalTimeout,
+		ExternalWorkers:         DefaultExternalWorkers,
+		FetchInterval:           DefaultFetchInterval,
+		Jitter:                  DefaultJitter,
+		LinkCheck:               false,
+		MaxBodySize:             DefaultMaxBodySize,
+		MaxFetchInterval:        DefaultMaxFetchInterval,
+		MinFetchInterval:        DefaultMinFetchInterval,
+		NetworkIdle:             DefaultNetworkIdle,
+		RenderAll:               false,
+		RenderTimeout:           DefaultRenderTimeout,
+		RespectRobots:           true,
+		RestrictToScheme:        false,
+		Retry:                   NewRetryPolicy(3),
+		RobotUserAgent:          DefaultRobotUserAgent,
+		UserAgent:               DefaultUserAgent,
+		ValidateMailto:          false,
+		WARCMaxSize:             DefaultWARCMaxSize,
+		WaitForBudget:           true,
 	}
 }
 
@@ -100,76 +168,223 @@ func (c *Config) SetFetchInterval(t time.Duration) {
 // image.
 type Page struct {
 	*url.URL
-	distance int
-	body     string
-	links    []string // immediate children
+	distance         int
+	referrer         string   // the page the url was found on
+	body             string   // the body, when it's kept in memory as is
+	zbody            []byte   // the gzipped body, when it's kept compressed
+	bodyFile         string   // the file with the gzipped body, when it's kept on disk
+	links            []string // immediate children
+	Links            []Link   // the page's a and area elements with their text and context, if it's html
+	Anchors          []string // the ids and a names that fragments can refer to, sorted, if it's html
+	Content                   // extracted from the body, if it's html and ExtractContent is set
+	contentExtracted bool
+
+	Accessibility        []Issue // the accessibility issues, if it's html and CheckAccessibility is set
+	accessibilityChecked bool
 }
 
 // ResponseInfo contains the status and error information from a get
 // TODO:
 //	Add Expire date
-//	Add time for request to return
 //	Should the body be in here?
 type ResponseInfo struct {
-	Status     string
+	Status          string
+	StatusCode      int
+	Proto           string // the protocol of the response, e.g. "HTTP/1.1"; empty if the fetcher doesn't know
+	ContentType     string
+	ContentLength   int64  // the Content-Length, -1 if it is unknown
+	ContentEncoding string // the Content-Encoding the body was decoded from
+	CompressedBytes int64  // the number of body bytes received, before any Content-Encoding was decoded
+	BodyBytes       int64  // the number of body bytes after decoding the Content-Encoding
+	Header          http.Header
+	Charset         string // the original character encoding of a text body; text bodies are decoded to UTF-8
+	Err             error
+	Duration        time.Duration     // how long the request took to return
+	FirstByte       time.Duration     // how long until the response's headers were received; 0 if the fetcher doesn't know
+	Truncated       bool              // whether the body was larger than the max body size
+	Meta            map[string]string // information about the content, set by its content handler
+	Attempts        []Attempt         // every attempt made to get the response
+	Redirects       []Redirect        // the redirects that were followed to get the response, in order
+}
+
+// Redirect is a redirect that was followed.
+type Redirect struct {
+	URL        string // the url that redirected
 	StatusCode int
-	Err        error
+	Location   string // the url it redirected to
+}
+
+// redirectClient returns a copy of c that records the redirects it follows in r.
+func redirectClient(c *http.Client, r *ResponseInfo) *http.Client {
+	rc := *c
+	rc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		// the same limit as the default policy
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		r.Redirects = append(r.Redirects, Redirect{URL: via[len(via)-1].URL.String(), StatusCode: req.Response.StatusCode, Location: req.URL.String()})
+		return nil
+	}
+	return &rc
 }
 
 // Site is a type that implements fetcher
 type Site struct {
 	*url.URL
+	Config     *Config                   // if nil, the defaults are used
+	handlers   map[string]ContentHandler // content handlers by media type
+	extractors map[string]LinkExtractor  // link extractors by media type
+}
+
+// Implements fetcher. The body is read up to Config.MaxBodySize bytes, anything
+// after that is discarded and the response is marked as truncated. The body is
+// processed by the content handler for its media type; html is handled by
+// default, with the links extracted as the body is read. If the response
+// doesn't have a Content-Type, it is sniffed from the body.
+func (s Site) Fetch(u string) (body string, r ResponseInfo, urls []string) {
+	body, r, urls, _ = s.fetch(Request{URL: u}, nil)
+	return body, r, urls
 }
 
-// Implements fetcher.
+// fetch gets the request's url, sending the request's headers. html that's
+// handled by geomi is scanned as it's read; what's found is returned, so it
+// doesn't have to be scanned again. If wire isn't nil, the requests are sent
+// with it, so it keeps each exchange, redirects included, as it was on the wire;
+// the body is read even if it isn't kept.
 // TODO: make the design cleaner
-func (s Site) Fetch(url string) (body string, r ResponseInfo, urls []string) {
-	// see if the passed url is outside of the baseURL
-	resp, err := http.Get(url)
+func (s Site) fetch(rq Request, wire *wireTransport) (body string, r ResponseInfo, urls []string, hp *htmlPage) {
+	u := rq.URL
+	c := s.Config
+	if c == nil {
+		c = NewConfig()
+	}
+	req, err := http.NewRequest("GET", u, nil)
 	if err != nil {
 		r.Err = err
-		return "", r, nil
+		return "", r, nil, nil
 	}
+	for k, v := range rq.Header {
+		req.Header[k] = v
+	}
+	// setting Accept-Encoding turns off the transport's transparent gzip
+	// handling, the body is decoded here so both sizes can be recorded
+	req.Header.Set("Accept-Encoding", AcceptEncoding)
+	client := http.DefaultClient
+	if wire != nil {
+		wc := *client
+		wire.rt = wc.Transport
+		wc.Transport = wire
+		client = &wc
+	}
+	start := time.Now()
+	resp, err := redirectClient(client, &r).Do(req)
+	if err != nil {
+		r.Err = err
+		return "", r, nil, nil
+	}
+	r.FirstByte = time.Since(start)
 	defer resp.Body.Close()
+	// relative links are relative to the page they are on, which, if there
+	// were redirects, isn't the url that was requested
+	base := resp.Request.URL
 	r.Status = resp.Status
 	r.StatusCode = resp.StatusCode
-	buff := &bytes.Buffer{}
-	tee := io.TeeReader(resp.Body, buff)
-	tokens := getTokens(tee)
-	if len(tokens) == 0 {
-		r.Err = fmt.Errorf("%s: nothing in body", url)
-		return "", r, nil
-	}
-	urls, err = s.linksFromTokens(tokens)
+	r.Proto = resp.Proto
+	r.ContentType = resp.Header.Get("Content-Type")
+	r.ContentLength = resp.ContentLength
+	r.ContentEncoding = resp.Header.Get("Content-Encoding")
+	r.Header = resp.Header
+	raw := &countingReader{r: resp.Body}
+	defer func() { r.CompressedBytes = raw.n }()
+	dec, err := decodeContent(raw, r.ContentEncoding)
 	if err != nil {
 		r.Err = err
-		return "", r, nil
+		return "", r, nil, nil
+	}
+	// the max body size applies to the decoded body
+	cr := &countingReader{r: dec}
+	if c.MaxBodySize > 0 {
+		cr.r = io.LimitReader(dec, c.MaxBodySize)
+	}
+	defer func() { r.BodyBytes = cr.n }()
+	br := bufio.NewReaderSize(cr, 1024)
+	if r.ContentType == "" {
+		peek, _ := br.Peek(512)
+		r.ContentType = http.DetectContentType(peek)
+	}
+	mt := mediaType(r.ContentType)
+	h := s.handler(mt)
+	if isHTML(mt) && !s.registered(mt) {
+		h = ContentHandlerFunc(func(base *url.URL, body io.Reader, r *ResponseInfo) (string, []string, error) {
+			buff := &bytes.Buffer{}
+			hp = scanHTML(base, io.TeeReader(body, buff), c.ExtractContent)
+			if hp.err != nil {
+				return "", nil, hp.err
+			}
+			return buff.String(), hp.urls, nil
+		})
+	}
+	if h == nil {
+		switch {
+		case c.DownloadNonHTML:
+			h = ContentHandlerFunc(readBody)
+		case wire != nil:
+			// the body isn't kept, but the bytes received are
+			h = ContentHandlerFunc(discardBody)
+		default:
+			// nothing to do if the body isn't wanted
+			return "", r, nil, nil
+		}
+	}
+	var in io.Reader = br
+	if isText(mt) {
+		in, r.Charset = decodeText(br, r.ContentType)
+	}
+	body, urls, err = h.Handle(base, in, &r)
+	if err != nil {
+		r.Err = err
+		return "", r, nil, nil
+	}
+	// a handler may not read everything; all of the bytes received are wanted
+	if wire != nil {
+		io.Copy(io.Discard, br)
 	}
-	return buff.String(), r, urls
+	// if there's anything left, the body was too big
+	if c.MaxBodySize > 0 && cr.n == c.MaxBodySize {
+		var b [1]byte
+		if n, _ := io.ReadFull(dec, b[:]); n > 0 {
+			r.Truncated = true
+		}
+	}
+	return body, r, urls, hp
 }
 
-// linksFromTokens returns a list of links (href a) found in the token slice
+// linksFromReader returns a list of links (href a) found in the html read from
+// r, resolved against base. Stylesheets and feeds, from link elements, are also
+// included so that their links can be crawled. The html is tokenized as it is
+// read, see scanHTML.
 // TODO should internal links be tracked separatly? i.e. record them in a
 // separate var (so they don't get fetched)
-func (s *Site) linksFromTokens(tokens []html.Token) ([]string, error) {
-	var links []string
-	for _, token := range tokens {
-		if token.Type == html.StartTagToken && token.DataAtom.String() == "a" {
-			for _, attr := range token.Attr {
-				// We only care about links that aren't #
-				if attr.Key == "href" && !strings.HasPrefix(attr.Val, "#") {
-					// parse the url
-					url, err := url.Parse(attr.Val)
-					if err != nil {
-						return nil, err
-					}
-					link := s.URL.ResolveReference(url)
-					links = append(links, link.String())
-				}
+func linksFromReader(base *url.URL, r io.Reader) ([]string, error) {
+	p := scanHTML(base, r, false)
+	return p.urls, p.err
+}
+
+// linkedResource returns whether a link element, with the rel and type, refers
+// to something that should be crawled: a stylesheet or a feed.
+func linkedResource(rel, typ string) bool {
+	for _, v := range strings.Fields(strings.ToLower(rel)) {
+		switch v {
+		case "stylesheet":
+			return true
+		case "alternate":
+			switch mediaType(typ) {
+			case "application/rss+xml", "application/atom+xml":
+				return true
 			}
 		}
 	}
-	return links, nil
+	return false
 }
 
 // Spider crawls the target. It contains all information needed to manage the crawl
@@ -177,17 +392,28 @@ func (s *Site) linksFromTokens(tokens []html.Token) ([]string, error) {
 type Spider struct {
 	*queue.Queue
 	sync.Mutex
-	wg            sync.WaitGroup
 	*url.URL      // the start url
 	Config        *Config
 	robots        *robotstxt.Group
 	maxDepth      int
 	Pages         map[string]Page
-	foundURLs     map[string]struct{}     // keeps track of urls found to prevent recrawling
-	fetchedURLs   map[string]ResponseInfo // urls that have been fetched with their status
-	skippedURLs   map[string]struct{}     // urls within the same domain that are not retrieved
-	externalHosts map[string]struct{}     // list of external hosts TODO: elide?
-	externalLinks map[string]ResponseInfo // list of external links; if fetched,
+	foundURLs     map[string]struct{}       // keeps track of urls found to prevent recrawling
+	fetchedURLs   map[string]ResponseInfo   // urls that have been fetched with their status
+	skippedURLs   map[string]struct{}       // urls within the same domain that are not retrieved
+	externalHosts map[string]struct{}       // list of external hosts TODO: elide?
+	externalLinks map[string]ResponseInfo   // list of external links; if fetched,
+	referrers     map[string][]Referrer     // urls and the pages that link to them; only used in LinkCheck mode
+	checks        map[string]*externalCheck // external link checks, keyed by normalized url
+	hostLimits    map[string]*hostLimiter   // per external host request limits
+	throttle      *throttle                 // adjusts the fetch interval; only used when AdaptiveThrottle is set
+	budget        *budget                   // the crawl's limits and what has been used
+	stopReason    string                    // why the crawl was stopped before it finished, if it was
+	handlers      map[string]ContentHandler // content handlers by media type, for the Site
+	extractors    map[string]LinkExtractor  // link extractors by media type, for the Site
+	bodyDir       string                    // where bodies are written when they are kept on disk
+	schemeLinks   map[string]*SchemeLink    // links whose scheme isn't http or https; they aren't fetched
+	warc          *WARCWriter               // writes the WARC files, when WARCDir is set
+	exchanger     exchanger                 // records, or replays, the requests not made by the fetcher; nil if it doesn't
 }
 
 // returns a Spider with the its site's baseUrl set. The baseUrl is the start point for
@@ -206,6 +432,10 @@ func NewSpider(start string) (*Spider, error) {
 		skippedURLs:   make(map[string]struct{}),
 		externalHosts: make(map[string]struct{}),
 		externalLinks: make(map[string]ResponseInfo),
+		referrers:     make(map[string][]Referrer),
+		checks:        make(map[string]*externalCheck),
+		hostLimits:    make(map[string]*hostLimiter),
+		schemeLinks:   make(map[string]*SchemeLink),
 	}
 	spider.URL, err = url.Parse(start)
 	if err != nil {
@@ -230,6 +460,10 @@ func NewSpiderFromConfig(start string, c *Config) (*Spider, error) {
 		skippedURLs:   make(map[string]struct{}),
 		externalHosts: make(map[string]struct{}),
 		externalLinks: make(map[string]ResponseInfo),
+		referrers:     make(map[string][]Referrer),
+		checks:        make(map[string]*externalCheck),
+		hostLimits:    make(map[string]*hostLimiter),
+		schemeLinks:   make(map[string]*SchemeLink),
 	}
 	spider.URL, err = url.Parse(start)
 	if err != nil {
@@ -267,19 +501,93 @@ func (s *Spider) ExternalLinks() []string {
 // baseURL. If depth == -1, no limits are set and it is expected that the entire site
 // will be crawled.
 func (s *Spider) Crawl(depth int) (message string, err error) {
+	S := Site{URL: s.URL, Config: s.Config, handlers: s.handlers, extractors: s.extractors}
+	var f RequestFetcher = S
+	// pages are only rendered if asked to, starting a browser isn't cheap
+	if s.Config.RenderAll || len(s.Config.RenderPatterns) > 0 {
+		rf, err := newRenderFetcher(s.Config, S)
+		if err != nil {
+			return "", err
+		}
+		b, err := s.browser()
+		if err != nil {
+			return "", err
+		}
+		defer b.Close()
+		rf.browser = b
+		f = rf
+	}
+	return s.CrawlWith(f, depth)
+}
+
+// CrawlWith crawls from the start url, like Crawl, but fetches every url with
+// the fetcher instead of the Spider's Site. The robots.txt, if it's respected,
+// and the external links are still requested over http, unless the fetcher is
+// a Recorder or Replayer, which records or replays them with the crawl. Use
+// AdaptFetcher to crawl with a Fetcher.
+func (s *Spider) CrawlWith(f RequestFetcher, depth int) (message string, err error) {
 	s.maxDepth = depth
-	S := Site{URL: s.URL}
+	if x, ok := f.(exchanger); ok {
+		s.exchanger = x
+	}
 	// if we are to respect the robots.txt, set up the info
 	if s.Config.RespectRobots {
 		s.getRobotsTxt()
 	}
+	if s.Config.WARCDir != "" {
+		w, err := NewWARCWriter(s.Config.WARCDir, "", s.Config.WARCMaxSize)
+		if err != nil {
+			return "", err
+		}
+		s.warc = w
+		f = WARCFetcher{Fetcher: f, Writer: w}
+	}
 	s.Queue.Enqueue(Page{URL: s.URL})
-	err = s.crawl(S)
-	return fmt.Sprintf("%d nodes were processed; %d external links linking to %d external hosts were not processed", len(s.Pages), len(s.externalLinks), len(s.externalHosts)), err
+	err = s.crawl(f)
+	if s.warc != nil {
+		if werr := f.(WARCFetcher).Err(); err == nil {
+			err = werr
+		}
+		if cerr := s.warc.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if s.Config.MirrorDir != "" {
+		if merr := s.Mirror(s.Config.MirrorDir); err == nil {
+			err = merr
+		}
+	}
+	message = fmt.Sprintf("%d nodes were processed; %d external links linking to %d external hosts were not processed", len(s.Pages), len(s.externalLinks), len(s.externalHosts))
+	if s.stopReason != "" {
+		message += "; the crawl was stopped: " + s.stopReason
+	}
+	return message, err
+}
+
+// finalURL returns the url of the response to a request for u: the location of
+// the last redirect that was followed, if there were any.
+func finalURL(u *url.URL, r ResponseInfo) *url.URL {
+	if n := len(r.Redirects); n > 0 {
+		if f, err := u.Parse(r.Redirects[n-1].Location); err == nil {
+			return f
+		}
+	}
+	return u
 }
 
 // This crawl does all the work.
-func (s *Spider) crawl(fetcher Fetcher) error {
+func (s *Spider) crawl(fetcher RequestFetcher) error {
+	external := s.newExternalChecker()
+	// wait for any external link checks that are still running; if the crawl
+	// was stopped, the ones that haven't started aren't done
+	defer func() { external.close(s.stopReason != "") }()
+	if s.Config.AdaptiveThrottle && s.throttle == nil {
+		s.throttle = newThrottle(s.Config)
+	}
+	if s.budget == nil {
+		s.budget = newBudget(s.Config, time.Now())
+	}
+	defer func() { s.budget.end = time.Now() }()
 	for !s.Queue.IsEmpty() {
 		// get next item from queue
 		p, ok := s.Queue.Dequeue()
@@ -292,10 +600,17 @@ func (s *Spider) crawl(fetcher Fetcher) error {
 			return nil
 		}
 		page := p.(Page)
+		// mailto, tel, javascript, etc. can't be fetched; they aren't external
+		// either, they have no host. Links are checked as they are queued, this
+		// catches a start url that isn't http.
+		if !fetchable(page.URL) {
+			s.addSchemeLink(page.URL, "")
+			continue
+		}
 		// see if this is an external url
 		if s.externalURL(page.URL) {
-			if s.Config.CheckExternalLinks {
-				s.fetchExternalLink(page.URL)
+			if s.Config.CheckExternalLinks || s.Config.LinkCheck {
+				external.check(page.URL)
 			}
 			continue
 		}
@@ -304,24 +619,102 @@ func (s *Spider) crawl(fetcher Fetcher) error {
 			// see if the skipped is external and process accordingly
 			continue
 		}
+		// stop if any of the crawl's budgets have been used up
+		if s.stopReason = s.budget.exhausted(time.Now()); s.stopReason != "" {
+			return nil
+		}
+		if s.stopReason = s.takeRequest(); s.stopReason != "" {
+			return nil
+		}
 		s.foundURLs[page.URL.String()] = struct{}{}
-		// get the url
-		r := ResponseInfo{}
-		page.body, r, page.links = fetcher.Fetch(page.URL.String())
+		// get the url, retrying according to the retry policy
+		res := s.fetch(fetcher, s.request(page))
+		body, r, links := res.Body, res.ResponseInfo, res.URLs
+		// fragments are validated separately, the url is what's crawled
+		for i, l := range links {
+			links[i] = stripFragment(l)
+		}
+		page.links = links
+		// fetchers other than the Site may not say what the body is
+		contentType := r.ContentType
+		if contentType == "" && body != "" {
+			contentType = http.DetectContentType([]byte(body))
+		}
+		if isHTML(mediaType(contentType)) {
+			hp := res.html
+			// the Site scans the html it handles as it's read
+			if hp == nil || (s.Config.ExtractContent && !hp.hasContent) {
+				hp = scanHTML(finalURL(page.URL, r), strings.NewReader(body), s.Config.ExtractContent)
+			}
+			page.Links = hp.links
+			page.Anchors = hp.anchors
+			if s.Config.ExtractContent {
+				page.Content = hp.content
+				page.contentExtracted = true
+			}
+			// the hreflang audit needs every page's alternates
+			page.Alternates = hp.alternates
+			// the checks need the document's tree
+			if s.Config.CheckAccessibility {
+				if doc, err := html.Parse(strings.NewReader(body)); err == nil {
+					page.Accessibility = checkAccessibility(doc)
+					page.accessibilityChecked = true
+				}
+			}
+		}
+		page.addAlternates(headerAlternates(finalURL(page.URL, r), r.Header))
+		if err := s.storeBody(&page, body); err != nil {
+			return fmt.Errorf("crawl: storing the body of %s: %w", page.URL, err)
+		}
 		// add the page and status to the map. map isn't checked for membership becuase we don't
 		// fetch found urls.
 		s.Lock()
 		s.Pages[page.URL.String()] = page
 		s.fetchedURLs[page.URL.String()] = r
 		s.Unlock()
+		s.budget.pages++
+		// fetchers that don't count what they receive are charged for the body
+		if r.CompressedBytes > 0 {
+			s.budget.bytes += r.CompressedBytes
+		} else {
+			s.budget.bytes += int64(len(body))
+		}
+		if s.Config.LinkCheck {
+			s.addReferrers(page)
+		}
+		// a retry may have run out of budget
+		if s.stopReason != "" {
+			return nil
+		}
 		// add the urls that the node contains to the queue
 		for _, l := range page.links {
-			u, _ := url.Parse(l)
-			s.Queue.Enqueue(Page{URL: u, distance: page.distance + 1})
+			u, err := url.Parse(l)
+			if err != nil {
+				continue
+			}
+			if !fetchable(u) {
+				s.addSchemeLink(u, page.URL.String())
+				continue
+			}
+			s.Queue.Enqueue(Page{URL: u, distance: page.distance + 1, referrer: page.URL.String()})
+		}
+		// hreflang alternates aren't links, but they're fetched so they can be
+		// audited
+		for _, a := range page.Alternates {
+			if u, err := url.Parse(a.URL); err == nil && fetchable(u) {
+				s.Queue.Enqueue(Page{URL: u, distance: page.distance + 1, referrer: page.URL.String()})
+			}
 		}
 		// if there is a wait between fetches, sleep for that + random jitter
-		if s.Config.FetchInterval > 0 {
-			wait := s.Config.FetchInterval
+		interval := s.Config.FetchInterval
+		if s.throttle != nil {
+			for _, a := range r.Attempts {
+				s.throttle.observe(a)
+			}
+			interval = s.throttle.interval
+		}
+		if interval > 0 {
+			wait := interval
 			// if there is a value for jitter, add a random jitter
 			if s.Config.Jitter > 0 {
 				n := s.Config.Jitter.Nanoseconds()
@@ -399,41 +792,24 @@ func (s *Spider) externalURL(u *url.URL) bool {
 	return false
 }
 
-// fetchExternalLink: fetches an external link's HEAD and check's it status. Note, this
-// does not implement fetcher
-func (s *Spider) fetchExternalLink(u *url.URL) error {
-	// if this has already benn fetched, don't
-	var ri ResponseInfo
-	s.Lock()
-	r, _ := s.externalLinks[u.String()]
-	s.Unlock()
-	if r != ri { // if !0 value, it's been retrieved
-		return nil
-	}
-	resp, err := http.Head(u.String())
-	if err != nil {
-		r.Err = err
-		s.Lock()
-		s.externalLinks[u.String()] = r
-		s.Unlock()
-		return err
-	}
-	r.Status = resp.Status
-	r.StatusCode = resp.StatusCode
-	s.Lock()
-	s.externalLinks[u.String()] = r
-	s.Unlock()
-	return nil
-}
-
 // getRobotsTxt retrieves and processes the site's robot.txt. If the robots.txt doesn't
 // exist, it is assumed that everything is allowed.
 func (s *Spider) getRobotsTxt() error {
-	resp, err := http.Get("http://" + s.URL.Host)
-	if err != nil {
-		return err
+	u := s.URL.Scheme + "://" + s.URL.Host + "/robots.txt"
+	res := s.exchange("robots", "GET", u, func() Result {
+		resp, err := http.Get(u)
+		if err != nil {
+			return Result{ResponseInfo: ResponseInfo{Err: err}}
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		r := ResponseInfo{Status: resp.Status, StatusCode: resp.StatusCode, Proto: resp.Proto, ContentType: resp.Header.Get("Content-Type"), Header: resp.Header, Err: err}
+		return Result{Body: string(b), ResponseInfo: r}
+	})
+	if res.Err != nil {
+		return res.Err
 	}
-	robots, err := robotstxt.FromResponse(resp)
+	robots, err := robotstxt.FromResponse(&http.Response{StatusCode: res.StatusCode, Header: res.Header, Body: io.NopCloser(strings.NewReader(res.Body))})
 	if err != nil {
 		return err
 	}
@@ -441,6 +817,16 @@ func (s *Spider) getRobotsTxt() error {
 	return nil
 }
 
+// exchange makes a request that isn't made by the crawl's fetcher, with do,
+// through the fetcher's exchanger, if it has one, so the request is recorded, or
+// replayed, with the crawl.
+func (s *Spider) exchange(kind, method, url string, do func() Result) Result {
+	if s.exchanger == nil {
+		return do()
+	}
+	return s.exchanger.exchange(kind, method, url, do)
+}
+
 // robotsAllowed checks to see if the passed path is allowed by Robots.txt. If the
 // robots isn't set, it's always true
 func (s *Spider) robotsAllowed(u *url.URL) bool {
@@ -450,19 +836,6 @@ func (s *Spider) robotsAllowed(u *url.URL) bool {
 	return true
 }
 
-// getTokens returns all tokens in the body
-func getTokens(body io.Reader) []html.Token {
-	tokens := make([]html.Token, 0)
-	page := html.NewTokenizer(body)
-	for {
-		typ := page.Next()
-		if typ == html.ErrorToken {
-			return tokens
-		}
-		tokens = append(tokens, page.Token())
-	}
-}
-
 func init() {
 	// We just use math/rand because it's good enough for our purpose.
 	rand.Seed(time.Now().UTC().UnixNano())
@@ -2,8 +2,12 @@ package geomi
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -148,7 +152,7 @@ func TestCrawl(t *testing.T) {
 		u, _ := url.Parse("http://golang.org/")
 		s.Queue.Enqueue(Page{URL: u})
 		s.maxDepth = test.depth
-		err := s.crawl(tester)
+		err := s.crawl(AdaptFetcher(tester))
 		if test.expectedErr == "" && err != nil {
 			t.Errorf("Expected error to be nil, got %q", err)
 			continue
@@ -264,7 +268,7 @@ func TestFetchInterval(t *testing.T) {
 	}
 	t1 := time.Now()
 	s.maxDepth = 1
-	s.crawl(tester)
+	s.crawl(AdaptFetcher(tester))
 	ts := time.Now().Sub(t1)
 	// the time it took should be in the range of 3 * (fetchInterval) - 3 * (fetchInterval + intervalJitter)
 	if ts < (3*s.Config.FetchInterval) || ts > (3*(s.Config.FetchInterval+s.Config.Jitter)) {
@@ -326,3 +330,47 @@ func TestExternalLinks(t *testing.T) {
 	}
 
 }
+
+func TestSiteFetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/empty":
+		case "/big":
+			fmt.Fprint(w, `<a href="first">first</a>`)
+			fmt.Fprint(w, strings.Repeat("x", 1000))
+			fmt.Fprint(w, `<a href="last">last</a>`)
+		default:
+			fmt.Fprint(w, `<html><body><a href="a/">a</a><a href="#top">top</a><a href="/b">b</a></body></html>`)
+		}
+	}))
+	defer ts.Close()
+	c := NewConfig()
+	c.MaxBodySize = 500
+	tests := []struct {
+		path      string
+		urls      []string
+		bodyLen   int
+		truncated bool
+		err       bool
+	}{
+		{"/doc/", []string{ts.URL + "/doc/a/", ts.URL + "/b"}, 84, false, false},
+		{"/big", []string{ts.URL + "/first"}, 500, true, false},
+		{"/empty", nil, 0, false, false},
+	}
+	for _, test := range tests {
+		s := Site{URL: nil, Config: c}
+		body, r, urls := s.Fetch(ts.URL + test.path)
+		if (r.Err != nil) != test.err {
+			t.Errorf("%s: expected error to be %t, got %v", test.path, test.err, r.Err)
+		}
+		if len(body) != test.bodyLen {
+			t.Errorf("%s: expected body to be %d bytes, got %d", test.path, test.bodyLen, len(body))
+		}
+		if r.Truncated != test.truncated {
+			t.Errorf("%s: expected truncated to be %t, got %t", test.path, test.truncated, r.Truncated)
+		}
+		if !reflect.DeepEqual(urls, test.urls) {
+			t.Errorf("%s: expected urls to be %v, got %v", test.path, test.urls, urls)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package geomi
+
+import (
+	"net/url"
+	"sort"
+)
+
+// LinkKind describes the relationship between a link's target and the site being
+// crawled.
+type LinkKind string
+
+const (
+	LinkInternal LinkKind = "internal" // the target is part of the crawl
+	LinkExternal LinkKind = "external" // the target is on a different host
+	LinkSkipped  LinkKind = "skipped"  // the target is on the same host but wasn't crawled
+	LinkOther    LinkKind = "other"    // the target's scheme isn't http or https, e.g. mailto; it can't be crawled
+)
+
+// Node is a url in the link graph. Nodes that were not fetched by the spider,
+// e.g. external links, have their distance set to one more than the closest page
+// that links to them.
+type Node struct {
+	URL         string
+	Kind        LinkKind
+	Distance    int
+	StatusCode  int
+	ContentType string
+	Fetched     bool // whether the spider retrieved this node
+}
+
+// Edge is a link from one node to another. Weight is the number of times the
+// link appears on the source page.
+type Edge struct {
+	From   string
+	To     string
+	Kind   LinkKind
+	Weight int
+}
+
+// Graph is the link graph of a crawl. Nodes and Edges are sorted by url so that
+// the output of the exporters is stable.
+type Graph struct {
+	Seeds []string // the urls the crawl started from
+	Nodes []Node
+	Edges []Edge
+	index map[string]int // node url -> position in Nodes
+}
+
+// Node returns the node for the url and whether it exists in the graph.
+func (g *Graph) Node(u string) (Node, bool) {
+	i, ok := g.index[u]
+	if !ok {
+		return Node{}, false
+	}
+	return g.Nodes[i], true
+}
+
+// reindex rebuilds the url index of the nodes.
+func (g *Graph) reindex() {
+	g.index = make(map[string]int, len(g.Nodes))
+	for i, n := range g.Nodes {
+		g.index[n.URL] = i
+	}
+}
+
+// Graph returns the link graph built from the spider's pages. Every crawled page
+// is a node, as is every url that a crawled page links to.
+func (s *Spider) Graph() *Graph {
+	s.Lock()
+	defer s.Unlock()
+	nodes := make(map[string]*Node, len(s.Pages))
+	for k, p := range s.Pages {
+		r := s.fetchedURLs[k]
+		nodes[k] = &Node{URL: k, Kind: LinkInternal, Distance: p.distance, StatusCode: r.StatusCode, ContentType: r.ContentType, Fetched: true}
+	}
+	edges := make(map[[2]string]*Edge)
+	for k, p := range s.Pages {
+		for _, l := range p.links {
+			kind := s.linkKind(l)
+			n, ok := nodes[l]
+			if !ok {
+				n = &Node{URL: l, Kind: kind, Distance: p.distance + 1}
+				if r, ok := s.externalLinks[l]; ok {
+					n.StatusCode = r.StatusCode
+					n.ContentType = r.ContentType
+				}
+				nodes[l] = n
+			}
+			if !n.Fetched && p.distance+1 < n.Distance {
+				n.Distance = p.distance + 1
+			}
+			e, ok := edges[[2]string{k, l}]
+			if !ok {
+				e = &Edge{From: k, To: l, Kind: kind}
+				edges[[2]string{k, l}] = e
+			}
+			e.Weight++
+		}
+	}
+	g := &Graph{
+		Seeds: []string{s.URL.String()},
+		Nodes: make([]Node, 0, len(nodes)),
+		Edges: make([]Edge, 0, len(edges)),
+	}
+	for _, n := range nodes {
+		g.Nodes = append(g.Nodes, *n)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].URL < g.Nodes[j].URL })
+	g.reindex()
+	for _, e := range edges {
+		g.Edges = append(g.Edges, *e)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+	return g
+}
+
+// linkKind classifies a link found on a crawled page. The caller must hold the
+// lock.
+func (s *Spider) linkKind(l string) LinkKind {
+	if _, ok := s.Pages[l]; ok {
+		return LinkInternal
+	}
+	if _, ok := s.schemeLinks[l]; ok {
+		return LinkOther
+	}
+	u, err := url.Parse(l)
+	if err != nil || u.Host != s.URL.Host {
+		return LinkExternal
+	}
+	return LinkSkipped
+}
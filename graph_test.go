@@ -0,0 +1,83 @@
+package geomi
+
+import (
+	"testing"
+)
+
+// linkTester has links to internal, skipped, and external urls.
+var linkTester = &testFetcher{
+	"http://golang.org/cmd/": &testResult{
+		"Commands",
+		[]string{
+			"http://golang.org/cmd/gofmt/",
+			"http://golang.org/cmd/gofmt/",
+			"http://golang.org/pkg/",
+			"https://github.com/golang/go",
+		},
+	},
+	"http://golang.org/cmd/gofmt/": &testResult{
+		"Command gofmt",
+		[]string{
+			"http://golang.org/cmd/",
+			"https://github.com/golang/go",
+		},
+	},
+}
+
+// crawledSpider returns a spider that has crawled the fetcher from start, with
+// CrawlWith. The robots.txt isn't respected and external links aren't checked,
+// unless the options, which are applied to the spider's config before the
+// crawl, say otherwise.
+func crawledSpider(t *testing.T, start string, f RequestFetcher, options ...func(*Config)) *Spider {
+	s, err := NewSpider(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Config.SetFetchInterval(0)
+	s.Config.CheckExternalLinks = false
+	s.Config.RespectRobots = false
+	for _, o := range options {
+		o(s.Config)
+	}
+	if _, err := s.CrawlWith(f, -1); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGraph(t *testing.T) {
+	s := crawledSpider(t, "http://golang.org/cmd/", AdaptFetcher(linkTester))
+	g := s.Graph()
+	nodes := []Node{
+		{URL: "http://golang.org/cmd/", Kind: LinkInternal, Distance: 0, Fetched: true},
+		{URL: "http://golang.org/cmd/gofmt/", Kind: LinkInternal, Distance: 1, Fetched: true},
+		{URL: "http://golang.org/pkg/", Kind: LinkSkipped, Distance: 1},
+		{URL: "https://github.com/golang/go", Kind: LinkExternal, Distance: 1},
+	}
+	if len(g.Nodes) != len(nodes) {
+		t.Fatalf("Expected %d nodes, got %d", len(nodes), len(g.Nodes))
+	}
+	for i, n := range nodes {
+		if g.Nodes[i] != n {
+			t.Errorf("Expected node %d to be %+v, got %+v", i, n, g.Nodes[i])
+		}
+	}
+	edges := []Edge{
+		{From: "http://golang.org/cmd/", To: "http://golang.org/cmd/gofmt/", Kind: LinkInternal, Weight: 2},
+		{From: "http://golang.org/cmd/", To: "http://golang.org/pkg/", Kind: LinkSkipped, Weight: 1},
+		{From: "http://golang.org/cmd/", To: "https://github.com/golang/go", Kind: LinkExternal, Weight: 1},
+		{From: "http://golang.org/cmd/gofmt/", To: "http://golang.org/cmd/", Kind: LinkInternal, Weight: 1},
+		{From: "http://golang.org/cmd/gofmt/", To: "https://github.com/golang/go", Kind: LinkExternal, Weight: 1},
+	}
+	if len(g.Edges) != len(edges) {
+		t.Fatalf("Expected %d edges, got %d", len(edges), len(g.Edges))
+	}
+	for i, e := range edges {
+		if g.Edges[i] != e {
+			t.Errorf("Expected edge %d to be %+v, got %+v", i, e, g.Edges[i])
+		}
+	}
+	if _, ok := g.Node("http://golang.org/pkg/"); !ok {
+		t.Error("Expected http://golang.org/pkg/ to be a node, it wasn't")
+	}
+}
@@ -0,0 +1,155 @@
+package geomi
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// ContentHandler processes the body of a response of a specific media type. It
+// returns the body to be kept, which may be empty, and the links found in it.
+// Information about the content can be added to the ResponseInfo's Meta.
+type ContentHandler interface {
+	Handle(base *url.URL, body io.Reader, r *ResponseInfo) (string, []string, error)
+}
+
+// ContentHandlerFunc is a function that implements ContentHandler.
+type ContentHandlerFunc func(base *url.URL, body io.Reader, r *ResponseInfo) (string, []string, error)
+
+// Handle calls f.
+func (f ContentHandlerFunc) Handle(base *url.URL, body io.Reader, r *ResponseInfo) (string, []string, error) {
+	return f(base, body, r)
+}
+
+// HandleContent registers the handler for the media type, e.g. "application/pdf".
+// A media type of the form "image/*" matches any media type of that type that
+// doesn't have its own handler. Registering a handler for "text/html" replaces
+// geomi's html handling.
+func (s *Site) HandleContent(mediaType string, h ContentHandler) {
+	if s.handlers == nil {
+		s.handlers = make(map[string]ContentHandler)
+	}
+	s.handlers[strings.ToLower(mediaType)] = h
+}
+
+// HandleContent registers the handler for the media type with the Site used by
+// Crawl. See Site.HandleContent.
+func (s *Spider) HandleContent(mediaType string, h ContentHandler) {
+	if s.handlers == nil {
+		s.handlers = make(map[string]ContentHandler)
+	}
+	s.handlers[strings.ToLower(mediaType)] = h
+}
+
+// handler returns the content handler for the media type, or nil if there isn't
+// one. Handlers for the exact media type are preferred, then link extractors for
+// it, then handlers for the type's wildcard, then geomi's link extractors and
+// content handlers.
+func (s *Site) handler(mediaType string) ContentHandler {
+	if h, ok := s.handlers[mediaType]; ok {
+		return h
+	}
+	if e, ok := s.extractors[mediaType]; ok {
+		return ExtractorHandler(e)
+	}
+	if i := strings.Index(mediaType, "/"); i > 0 {
+		if h, ok := s.handlers[mediaType[:i]+"/*"]; ok {
+			return h
+		}
+	}
+	if e, ok := defaultExtractors[mediaType]; ok {
+		return ExtractorHandler(e)
+	}
+	if h, ok := defaultHandlers[mediaType]; ok {
+		return h
+	}
+	return nil
+}
+
+// registered returns whether a content handler or link extractor was registered
+// for the media type, or a handler for its wildcard.
+func (s *Site) registered(mediaType string) bool {
+	if _, ok := s.handlers[mediaType]; ok {
+		return true
+	}
+	if _, ok := s.extractors[mediaType]; ok {
+		return true
+	}
+	if i := strings.Index(mediaType, "/"); i > 0 {
+		if _, ok := s.handlers[mediaType[:i]+"/*"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns the lower cased media type of a Content-Type, without any
+// parameters.
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// use what's there, sans parameters
+		mt = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	}
+	return strings.ToLower(mt)
+}
+
+// isHTML returns whether the media type is html.
+func isHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// ExtractorHandler returns a ContentHandler that keeps the body and uses e to
+// find its links.
+func ExtractorHandler(e LinkExtractor) ContentHandler {
+	return ContentHandlerFunc(func(base *url.URL, body io.Reader, r *ResponseInfo) (string, []string, error) {
+		buff := &bytes.Buffer{}
+		urls, err := e.ExtractLinks(base, io.TeeReader(body, buff))
+		if err != nil {
+			return "", nil, err
+		}
+		// make sure everything has been read, some extractors stop early
+		if _, err := io.Copy(buff, body); err != nil {
+			return "", nil, err
+		}
+		return buff.String(), urls, nil
+	})
+}
+
+// readBody keeps the body; there are no links.
+func readBody(base *url.URL, body io.Reader, r *ResponseInfo) (string, []string, error) {
+	b, err := io.ReadAll(body)
+	return string(b), nil, err
+}
+
+// discardBody reads the body without keeping it.
+func discardBody(base *url.URL, body io.Reader, r *ResponseInfo) (string, []string, error) {
+	_, err := io.Copy(io.Discard, body)
+	return "", nil, err
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
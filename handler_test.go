@@ -0,0 +1,134 @@
+package geomi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestMediaType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    string
+	}{
+		{"", ""},
+		{"text/html", "text/html"},
+		{"Text/HTML; charset=UTF-8", "text/html"},
+		{"application/pdf", "application/pdf"},
+		{"text/html; charset", "text/html"},
+	}
+	for _, test := range tests {
+		if mt := mediaType(test.contentType); mt != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.contentType, test.expected, mt)
+		}
+	}
+}
+
+func TestContentHandlers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cat.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG\r\n\x1a\n"))
+		case "/data.json":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"href": "<a href=\"/nope\">"}`)
+		case "/sniffed":
+			w.Header()["Content-Type"] = nil
+			fmt.Fprint(w, `<!DOCTYPE html><html><a href="/yes">yes</a></html>`)
+		}
+	}))
+	defer ts.Close()
+	var imageCalls int
+	images := ContentHandlerFunc(func(base *url.URL, body io.Reader, r *ResponseInfo) (string, []string, error) {
+		imageCalls++
+		b, err := io.ReadAll(body)
+		r.Meta = map[string]string{"size": strconv.Itoa(len(b))}
+		return "", []string{"http://golang.org/from/image"}, err
+	})
+	tests := []struct {
+		path        string
+		download    bool
+		images      bool
+		contentType string
+		body        string
+		urls        []string
+		meta        map[string]string
+	}{
+		{"/cat.png", true, false, "image/png", "\x89PNG\r\n\x1a\n", nil, nil},
+		{"/cat.png", false, false, "image/png", "", nil, nil},
+		{"/cat.png", false, true, "image/png", "", []string{"http://golang.org/from/image"}, map[string]string{"size": "8"}},
+		{"/data.json", true, false, "application/json", `{"href": "<a href=\"/nope\">"}`, nil, nil},
+		{"/sniffed", false, false, "text/html; charset=utf-8", `<!DOCTYPE html><html><a href="/yes">yes</a></html>`, []string{ts.URL + "/yes"}, nil},
+	}
+	for _, test := range tests {
+		c := NewConfig()
+		c.DownloadNonHTML = test.download
+		s := Site{Config: c}
+		if test.images {
+			s.HandleContent("image/*", images)
+		}
+		body, r, urls := s.Fetch(ts.URL + test.path)
+		if r.Err != nil {
+			t.Errorf("%s: expected no error, got %q", test.path, r.Err)
+			continue
+		}
+		if r.ContentType != test.contentType {
+			t.Errorf("%s: expected content type %q, got %q", test.path, test.contentType, r.ContentType)
+		}
+		if body != test.body {
+			t.Errorf("%s: expected body %q, got %q", test.path, test.body, body)
+		}
+		if !reflect.DeepEqual(urls, test.urls) {
+			t.Errorf("%s: expected urls %v, got %v", test.path, test.urls, urls)
+		}
+		if !reflect.DeepEqual(r.Meta, test.meta) {
+			t.Errorf("%s: expected meta %v, got %v", test.path, test.meta, r.Meta)
+		}
+	}
+	if imageCalls != 1 {
+		t.Errorf("Expected the image handler to be called once, was called %d times", imageCalls)
+	}
+}
+
+func TestEmptyHTML(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+	tests := []struct {
+		path   string
+		code   int
+		reason string
+	}{
+		{"/", http.StatusOK, ""},
+		{"/missing", http.StatusNotFound, BrokenClientError},
+		{"/nocontent", http.StatusNoContent, ""},
+	}
+	for _, test := range tests {
+		body, r, _ := Site{Config: NewConfig()}.Fetch(ts.URL + test.path)
+		if r.Err != nil {
+			t.Errorf("%s: expected no error, got %v", test.path, r.Err)
+		}
+		if body != "" {
+			t.Errorf("%s: expected an empty body, got %q", test.path, body)
+		}
+		if r.StatusCode != test.code {
+			t.Errorf("%s: expected status code %d, got %d", test.path, test.code, r.StatusCode)
+		}
+		if reason := brokenReason(r); reason != test.reason {
+			t.Errorf("%s: expected reason %q, got %q", test.path, test.reason, reason)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package geomi
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// XDefault is the hreflang of the alternate used when no other alternate matches
+// the user's language.
+const XDefault = "x-default"
+
+// HreflangRules returns the rules that validate hreflang alternates.
+func HreflangRules() []Rule {
+	return []Rule{
+		BrokenHreflangRule{},
+		HreflangCodeRule{},
+		HreflangReturnLinkRule{},
+		HreflangXDefaultRule{},
+	}
+}
+
+// AuditHreflang runs the HreflangRules against every page that has alternates,
+// including pages that aren't html, e.g. pdfs with alternates in their Link
+// header.
+func (s *Spider) AuditHreflang() map[string][]Issue {
+	return s.audit(func(c *AuditContext, u string) bool {
+		return len(c.Pages[u].Alternates) > 0
+	}, HreflangRules())
+}
+
+// HreflangCodeRule finds alternates whose hreflang isn't a valid language code.
+type HreflangCodeRule struct{}
+
+func (HreflangCodeRule) Name() string { return "invalid-hreflang" }
+
+func (r HreflangCodeRule) Check(c *AuditContext, p Page) []Issue {
+	var issues []Issue
+	for _, a := range p.Alternates {
+		if err := validHreflang(a.Hreflang); err != nil {
+			issues = append(issues, Issue{r.Name(), SeverityError, fmt.Sprintf("the alternate %s has an invalid hreflang: %s", a.URL, err)})
+		}
+	}
+	return issues
+}
+
+// HreflangReturnLinkRule finds alternates that don't link back to the page.
+// Alternates must reference each other; if they don't, search engines ignore
+// them. Only alternates that were crawled successfully are checked.
+type HreflangReturnLinkRule struct{}
+
+func (HreflangReturnLinkRule) Name() string { return "missing-hreflang-return-link" }
+
+func (r HreflangReturnLinkRule) Check(c *AuditContext, p Page) []Issue {
+	var issues []Issue
+	self := p.URL.String()
+	for _, a := range p.Alternates {
+		if sameURL(a.URL, self) {
+			continue
+		}
+		target, ok := c.Pages[a.URL]
+		if !ok {
+			continue
+		}
+		// alternates that didn't return a 200 are BrokenHreflangRule's
+		if r, ok := c.Responses[a.URL]; ok && (r.Err != nil || r.StatusCode != http.StatusOK) {
+			continue
+		}
+		var found bool
+		for _, b := range target.Alternates {
+			if sameURL(b.URL, self) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			issues = append(issues, Issue{r.Name(), SeverityError, fmt.Sprintf("the %s alternate, %s, doesn't link back to the page", a.Hreflang, a.URL)})
+		}
+	}
+	return issues
+}
+
+// HreflangXDefaultRule finds pages with alternates but no x-default.
+type HreflangXDefaultRule struct{}
+
+func (HreflangXDefaultRule) Name() string { return "missing-x-default" }
+
+func (r HreflangXDefaultRule) Check(c *AuditContext, p Page) []Issue {
+	if len(p.Alternates) == 0 {
+		return nil
+	}
+	for _, a := range p.Alternates {
+		if strings.EqualFold(a.Hreflang, XDefault) {
+			return nil
+		}
+	}
+	return []Issue{{r.Name(), SeverityWarning, "the page has hreflang alternates but no x-default"}}
+}
+
+// validHreflang returns why the hreflang isn't valid, or nil if it is. A valid
+// hreflang is x-default or an ISO 639-1 language, optionally followed by an ISO
+// 15924 script, and optionally followed by an ISO 3166-1 alpha-2 region or a UN
+// M.49 area, separated by hyphens, e.g. "en", "en-GB", "zh-Hant-TW", "es-419".
+func validHreflang(code string) error {
+	if strings.EqualFold(code, XDefault) {
+		return nil
+	}
+	if strings.Contains(code, "_") {
+		return fmt.Errorf("%q: subtags must be separated by a hyphen", code)
+	}
+	parts := strings.Split(code, "-")
+	if len(parts[0]) != 2 {
+		return fmt.Errorf("%q: the language must be a 2 letter ISO 639-1 code", code)
+	}
+	if _, err := language.ParseBase(parts[0]); err != nil {
+		return fmt.Errorf("%q: unknown language %q", code, parts[0])
+	}
+	parts = parts[1:]
+	if len(parts) > 0 && len(parts[0]) == 4 {
+		if _, err := language.ParseScript(parts[0]); err != nil {
+			return fmt.Errorf("%q: unknown script %q", code, parts[0])
+		}
+		parts = parts[1:]
+	}
+	if len(parts) > 0 {
+		reg, err := language.ParseRegion(parts[0])
+		if err != nil || !(reg.IsCountry() || reg.IsGroup()) {
+			return fmt.Errorf("%q: unknown region %q", code, parts[0])
+		}
+		// UK is reserved, it isn't the ISO code for the United Kingdom
+		if strings.EqualFold(parts[0], "uk") {
+			return fmt.Errorf("%q: unknown region %q, use GB for the United Kingdom", code, parts[0])
+		}
+		parts = parts[1:]
+	}
+	if len(parts) > 0 {
+		return fmt.Errorf("%q: unexpected subtag %q", code, parts[0])
+	}
+	return nil
+}
+
+// addAlternates adds the alternates the page doesn't already have.
+func (p *Page) addAlternates(alts []Alternate) {
+	for _, a := range alts {
+		var found bool
+		for _, b := range p.Alternates {
+			if strings.EqualFold(a.Hreflang, b.Hreflang) && a.URL == b.URL {
+				found = true
+				break
+			}
+		}
+		if !found {
+			p.Alternates = append(p.Alternates, a)
+		}
+	}
+}
+
+// headerAlternates returns the hreflang alternates in the response's Link
+// headers, resolved against base, e.g.
+//
+//	Link: <https://example.com/ko/>; rel="alternate"; hreflang="ko"
+func headerAlternates(base *url.URL, h http.Header) []Alternate {
+	var alts []Alternate
+	for _, v := range h.Values("Link") {
+		for _, l := range parseLinkHeader(v) {
+			if !hasToken(l.params["rel"], "alternate") || l.params["hreflang"] == "" {
+				continue
+			}
+			alts = append(alts, Alternate{Hreflang: l.params["hreflang"], URL: resolveRef(base, l.target)})
+		}
+	}
+	return alts
+}
+
+// linkValue is a link from a Link header.
+type linkValue struct {
+	target string
+	params map[string]string // param names are lower cased
+}
+
+// parseLinkHeader parses a Link header's value, as defined by RFC 8288. Links
+// that can't be parsed are skipped.
+func parseLinkHeader(v string) []linkValue {
+	var links []linkValue
+	for {
+		v = strings.TrimLeft(v, " \t,")
+		if v == "" || v[0] != '<' {
+			// skip whatever this is, up to the next link
+			i := strings.Index(v, ",")
+			if i < 0 {
+				return links
+			}
+			v = v[i+1:]
+			continue
+		}
+		end := strings.IndexByte(v, '>')
+		if end < 0 {
+			return links
+		}
+		l := linkValue{target: strings.TrimSpace(v[1:end]), params: make(map[string]string)}
+		v = v[end+1:]
+		for {
+			v = strings.TrimLeft(v, " \t")
+			if v == "" || v[0] != ';' {
+				break
+			}
+			v = strings.TrimLeft(v[1:], " \t")
+			i := strings.IndexAny(v, "=;,")
+			if i < 0 {
+				i = len(v)
+			}
+			name := strings.ToLower(strings.TrimSpace(v[:i]))
+			v = v[i:]
+			var val string
+			if v != "" && v[0] == '=' {
+				v = strings.TrimLeft(v[1:], " \t")
+				val, v = linkParamValue(v)
+			}
+			// the first occurrence of a param is used
+			if _, ok := l.params[name]; !ok && name != "" {
+				l.params[name] = val
+			}
+		}
+		links = append(links, l)
+	}
+}
+
+// linkParamValue returns the quoted or unquoted param value at the start of v
+// and the rest of v.
+func linkParamValue(v string) (string, string) {
+	if v == "" || v[0] != '"' {
+		i := strings.IndexAny(v, ";,")
+		if i < 0 {
+			i = len(v)
+		}
+		return strings.TrimSpace(v[:i]), v[i:]
+	}
+	var b strings.Builder
+	for i := 1; i < len(v); i++ {
+		switch v[i] {
+		case '\\':
+			if i+1 < len(v) {
+				i++
+				b.WriteByte(v[i])
+			}
+		case '"':
+			return b.String(), v[i+1:]
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+	return b.String(), ""
+}
+
+// hasToken returns whether the space separated list has the token, ignoring
+// case.
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package geomi
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestValidHreflang(t *testing.T) {
+	tests := []struct {
+		code  string
+		valid bool
+	}{
+		{"en", true},
+		{"en-GB", true},
+		{"en-us", true},
+		{"zh-Hant", true},
+		{"zh-Hant-TW", true},
+		{"es-419", true},
+		{"x-default", true},
+		{"X-Default", true},
+		{"", false},
+		{"en_US", false},
+		{"en-UK", false},
+		{"eng", false},
+		{"jp", false},
+		{"us", false},
+		{"de-XX", false},
+		{"en-US-x", false},
+		{"zh-Abcd", false},
+	}
+	for _, test := range tests {
+		err := validHreflang(test.code)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid to be %t, got error %v", test.code, test.valid, err)
+		}
+	}
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []linkValue
+	}{
+		{"", nil},
+		{`<http://example.com/ko/>; rel="alternate"; hreflang="ko"`, []linkValue{{"http://example.com/ko/", map[string]string{"rel": "alternate", "hreflang": "ko"}}}},
+		{`</a>; REL=alternate; hreflang=en, </b>;rel="alternate next";title="a, b; c"`, []linkValue{
+			{"/a", map[string]string{"rel": "alternate", "hreflang": "en"}},
+			{"/b", map[string]string{"rel": "alternate next", "title": "a, b; c"}},
+		}},
+		{`junk, </c>; rel="x\"y"; rel=z; crossorigin`, []linkValue{{"/c", map[string]string{"rel": `x"y`, "crossorigin": ""}}}},
+	}
+	for _, test := range tests {
+		links := parseLinkHeader(test.value)
+		if !reflect.DeepEqual(links, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.value, test.expected, links)
+		}
+	}
+}
+
+func TestHeaderAlternates(t *testing.T) {
+	base, _ := url.Parse("http://example.com/docs/a.pdf")
+	h := http.Header{}
+	h.Add("Link", `</ko/docs/a.pdf>; rel="alternate"; hreflang="ko", </docs/a.pdf>; rel="canonical"`)
+	h.Add("Link", `<http://example.com/docs/a.pdf>; rel="alternate"; hreflang="x-default"`)
+	expected := []Alternate{
+		{"ko", "http://example.com/ko/docs/a.pdf"},
+		{"x-default", "http://example.com/docs/a.pdf"},
+	}
+	if alts := headerAlternates(base, h); !reflect.DeepEqual(alts, expected) {
+		t.Errorf("expected %v, got %v", expected, alts)
+	}
+}
+
+func TestHreflangRules(t *testing.T) {
+	en := page("http://golang.org/en/", Content{Alternates: []Alternate{{"en", "http://golang.org/en/"}, {"ko", "http://golang.org/ko/"}, {"ja", "http://golang.org/ja/"}, {"x-default", "http://golang.org/en/"}}})
+	c := &AuditContext{
+		Pages: map[string]Page{
+			"http://golang.org/en/": en,
+			"http://golang.org/ko/": page("http://golang.org/ko/", Content{Alternates: []Alternate{{"en", "http://golang.org/en/"}}}),
+			"http://golang.org/ja/": page("http://golang.org/ja/", Content{}),
+		},
+	}
+	tests := []struct {
+		rule     Rule
+		page     Page
+		expected []Issue
+	}{
+		{HreflangCodeRule{}, page("http://golang.org/x", Content{Alternates: []Alternate{{"en-UK", "http://golang.org/uk"}, {"en-GB", "http://golang.org/gb"}}}), []Issue{{"invalid-hreflang", SeverityError, `the alternate http://golang.org/uk has an invalid hreflang: "en-UK": unknown region "UK", use GB for the United Kingdom`}}},
+		{HreflangReturnLinkRule{}, en, []Issue{{"missing-hreflang-return-link", SeverityError, "the ja alternate, http://golang.org/ja/, doesn't link back to the page"}}},
+		{HreflangReturnLinkRule{}, c.Pages["http://golang.org/ko/"], nil},
+		{HreflangXDefaultRule{}, en, nil},
+		{HreflangXDefaultRule{}, c.Pages["http://golang.org/ko/"], []Issue{{"missing-x-default", SeverityWarning, "the page has hreflang alternates but no x-default"}}},
+		{HreflangXDefaultRule{}, c.Pages["http://golang.org/ja/"], nil},
+	}
+	for _, test := range tests {
+		issues := test.rule.Check(c, test.page)
+		if !reflect.DeepEqual(issues, test.expected) {
+			t.Errorf("%s: %s: expected %v, got %v", test.rule.Name(), test.page.URL, test.expected, issues)
+		}
+	}
+}
+
+func TestAuditHreflang(t *testing.T) {
+	html := ResponseInfo{StatusCode: 200, ContentType: "text/html"}
+	pdf := ResponseInfo{StatusCode: 200, ContentType: "application/pdf", Header: http.Header{}}
+	pdf.Header.Set("Link", `</ko/a.pdf>; rel="alternate"; hreflang="ko"`)
+	f := statusFetcher{
+		"http://golang.org/": {
+			`<link rel="alternate" hreflang="ko" href="/ko/"><link rel="alternate" hreflang="x-default" href="/">`,
+			html,
+			[]string{"http://golang.org/a.pdf"},
+		},
+		"http://golang.org/ko/":   {`<link rel="alternate" hreflang="en" href="/">`, html, nil},
+		"http://golang.org/a.pdf": {"%PDF-1.4", pdf, nil},
+	}
+	tests := []struct {
+		url      string
+		expected []string // the rules with issues
+	}{
+		{"http://golang.org/", nil},
+		{"http://golang.org/ko/", []string{"missing-x-default"}},
+		// the alternate from the pdf's Link header was crawled, and isn't there
+		{"http://golang.org/a.pdf", []string{"broken-hreflang", "missing-x-default"}},
+	}
+	// the alternates are found whether or not the content is extracted
+	for _, extract := range []bool{true, false} {
+		issues := crawledSpider(t, "http://golang.org/", AdaptFetcher(f), func(c *Config) { c.ExtractContent = extract }).AuditHreflang()
+		for _, test := range tests {
+			var rules []string
+			for _, i := range issues[test.url] {
+				rules = append(rules, i.Rule)
+			}
+			if !reflect.DeepEqual(rules, test.expected) {
+				t.Errorf("extract %t: %s: expected %v, got %v", extract, test.url, test.expected, rules)
+			}
+		}
+	}
+}
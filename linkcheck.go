@@ -0,0 +1,148 @@
+package geomi
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"syscall"
+)
+
+// Reasons a link is considered broken.
+const (
+	BrokenClientError = "4xx"
+	BrokenServerError = "5xx"
+	BrokenDNS         = "dns"
+	BrokenTLS         = "tls"
+	BrokenTimeout     = "timeout"
+	BrokenConnection  = "connection"
+	BrokenError       = "error" // any other error, e.g. an unparsable body
+)
+
+// Referrer is a page that links to a url, along with the link's anchor text.
+type Referrer struct {
+	Page string
+	Text string
+}
+
+// BrokenLink is a link that could not be retrieved, or that returned an error
+// status, along with every page that links to it.
+type BrokenLink struct {
+	URL      string
+	External bool
+	Reason   string
+	ResponseInfo
+	Referrers []Referrer
+}
+
+// brokenReason returns why the response is broken, or an empty string if it
+// isn't.
+func brokenReason(r ResponseInfo) string {
+	if r.Err != nil {
+		return errorReason(r.Err)
+	}
+	switch {
+	case r.StatusCode >= 500:
+		return BrokenServerError
+	case r.StatusCode >= 400:
+		return BrokenClientError
+	}
+	return ""
+}
+
+// errorReason classifies a request error.
+func errorReason(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return BrokenDNS
+	}
+	var certErr *tls.CertificateVerificationError
+	var hostErr x509.HostnameError
+	var authErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &certErr) || errors.As(err, &hostErr) || errors.As(err, &authErr) || errors.As(err, &invalidErr) || errors.As(err, &recordErr) {
+		return BrokenTLS
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return BrokenTimeout
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return BrokenConnection
+	}
+	return BrokenError
+}
+
+// addReferrers records page as a referrer of each of its links. The anchor text
+// is taken from the page's Links, if it is html. The page's links don't have
+// fragments, so neither do the urls the texts are looked up by.
+func (s *Spider) addReferrers(page Page) {
+	texts := make(map[string][]string)
+	for _, l := range page.Links {
+		u := stripFragment(l.URL)
+		texts[u] = append(texts[u], l.Text)
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, l := range page.links {
+		ref := Referrer{Page: page.URL.String()}
+		// a url may be linked to more than once; use the texts in order
+		if t := texts[l]; len(t) > 0 {
+			ref.Text = t[0]
+			texts[l] = t[1:]
+		}
+		s.referrers[l] = append(s.referrers[l], ref)
+	}
+}
+
+// BrokenLinks returns every broken internal and external link found during the
+// crawl, sorted by url. Referrers are only available if the crawl was done with
+// Config.LinkCheck set.
+func (s *Spider) BrokenLinks() []BrokenLink {
+	s.Lock()
+	defer s.Unlock()
+	var broken []BrokenLink
+	add := func(u string, r ResponseInfo, external bool) {
+		reason := brokenReason(r)
+		if reason == "" {
+			return
+		}
+		broken = append(broken, BrokenLink{URL: u, External: external, Reason: reason, ResponseInfo: r, Referrers: s.referrers[u]})
+	}
+	for u, r := range s.fetchedURLs {
+		add(u, r, false)
+	}
+	for u, r := range s.externalLinks {
+		add(u, r, true)
+	}
+	sort.Slice(broken, func(i, j int) bool { return broken[i].URL < broken[j].URL })
+	return broken
+}
+
+// WriteBrokenLinks writes a tab separated report of the broken links to w. Each
+// line has the broken url, the reason, the status or error, and a referring page
+// with its anchor text. A broken link with more than one referrer has one line
+// per referrer.
+func (s *Spider) WriteBrokenLinks(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	for _, l := range s.BrokenLinks() {
+		status := l.Status
+		if l.Err != nil {
+			status = l.Err.Error()
+		}
+		if len(l.Referrers) == 0 {
+			fmt.Fprintf(b, "%s\t%s\t%s\t\t\n", l.URL, l.Reason, status)
+			continue
+		}
+		for _, ref := range l.Referrers {
+			fmt.Fprintf(b, "%s\t%s\t%s\t%s\t%s\n", l.URL, l.Reason, status, ref.Page, ref.Text)
+		}
+	}
+	return b.Flush()
+}
@@ -0,0 +1,133 @@
+package geomi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// statusFetcher is a Fetcher whose results include response info.
+type statusFetcher map[string]statusResult
+
+type statusResult struct {
+	body string
+	r    ResponseInfo
+	urls []string
+}
+
+func (f statusFetcher) Fetch(url string) (string, ResponseInfo, []string) {
+	if res, ok := f[url]; ok {
+		return res.body, res.r, res.urls
+	}
+	return "", ResponseInfo{Status: "404 Not Found", StatusCode: 404}, nil
+}
+
+func TestBrokenReason(t *testing.T) {
+	tests := []struct {
+		r        ResponseInfo
+		expected string
+	}{
+		{ResponseInfo{StatusCode: 200}, ""},
+		{ResponseInfo{StatusCode: 301}, ""},
+		{ResponseInfo{StatusCode: 404}, BrokenClientError},
+		{ResponseInfo{StatusCode: 503}, BrokenServerError},
+		{ResponseInfo{Err: &url.Error{Op: "Get", URL: "http://x.invalid/", Err: &net.DNSError{Err: "no such host", Name: "x.invalid"}}}, BrokenDNS},
+		{ResponseInfo{Err: &url.Error{Op: "Get", URL: "http://x/", Err: context.DeadlineExceeded}}, BrokenTimeout},
+		{ResponseInfo{Err: &url.Error{Op: "Get", URL: "http://x/", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}}, BrokenConnection},
+		{ResponseInfo{Err: errors.New("http://x/: nothing in body")}, BrokenError},
+	}
+	for _, test := range tests {
+		if reason := brokenReason(test.r); reason != test.expected {
+			t.Errorf("%+v: expected %q, got %q", test.r, test.expected, reason)
+		}
+	}
+}
+
+func TestBrokenLinks(t *testing.T) {
+	ext := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer ext.Close()
+	f := statusFetcher{
+		"http://golang.org/": {
+			body: `<a href="/missing">Missing page</a><a href="` + ext.URL + `/gone">Gone</a><a href="` + ext.URL + `/ok">OK</a>`,
+			r:    ResponseInfo{Status: "200 OK", StatusCode: 200, ContentType: "text/html"},
+			urls: []string{"http://golang.org/missing", ext.URL + "/gone", ext.URL + "/ok"},
+		},
+		"http://golang.org/about": {
+			body: `<a href="/missing">gone</a>`,
+			r:    ResponseInfo{Status: "200 OK", StatusCode: 200, ContentType: "text/html"},
+			urls: []string{"http://golang.org/missing"},
+		},
+	}
+	s, _ := NewSpider("http://golang.org/")
+	s.Config.SetFetchInterval(0)
+	s.Config.CheckExternalLinks = false
+	s.Config.LinkCheck = true
+	s.maxDepth = -1
+	u, _ := url.Parse("http://golang.org/")
+	s.Queue.Enqueue(Page{URL: u})
+	u, _ = url.Parse("http://golang.org/about")
+	s.Queue.Enqueue(Page{URL: u})
+	if err := s.crawl(AdaptFetcher(f)); err != nil {
+		t.Fatal(err)
+	}
+	broken := make(map[string]BrokenLink)
+	for _, l := range s.BrokenLinks() {
+		broken[l.URL] = l
+	}
+	if len(broken) != 2 {
+		t.Fatalf("Expected 2 broken links, got %d: %+v", len(broken), broken)
+	}
+	l := broken["http://golang.org/missing"]
+	if l.External || l.Reason != BrokenClientError {
+		t.Errorf("Expected an internal 4xx for /missing, got %+v", l)
+	}
+	refs := []Referrer{{"http://golang.org/", "Missing page"}, {"http://golang.org/about", "gone"}}
+	if !reflect.DeepEqual(l.Referrers, refs) {
+		t.Errorf("Expected referrers to be %v, got %v", refs, l.Referrers)
+	}
+	l = broken[ext.URL+"/gone"]
+	if !l.External || l.StatusCode != http.StatusGone {
+		t.Errorf("Expected an external 410 for /gone, got %+v", l)
+	}
+	var buf bytes.Buffer
+	if err := s.WriteBrokenLinks(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 3 {
+		t.Errorf("Expected 3 report lines, got %d: %q", n, buf.String())
+	}
+}
+
+func TestBrokenLinksFragment(t *testing.T) {
+	f := statusFetcher{
+		"http://golang.org/": {
+			body: `<a href="/missing#install">Docs</a>`,
+			r:    ResponseInfo{Status: "200 OK", StatusCode: 200, ContentType: "text/html"},
+			urls: []string{"http://golang.org/missing#install"},
+		},
+	}
+	s := crawledSpider(t, "http://golang.org/", AdaptFetcher(f), func(c *Config) { c.LinkCheck = true })
+	broken := s.BrokenLinks()
+	if len(broken) != 1 {
+		t.Fatalf("Expected 1 broken link, got %d: %+v", len(broken), broken)
+	}
+	if broken[0].URL != "http://golang.org/missing" {
+		t.Errorf("Expected the broken link to be http://golang.org/missing, got %q", broken[0].URL)
+	}
+	refs := []Referrer{{"http://golang.org/", "Docs"}}
+	if !reflect.DeepEqual(broken[0].Referrers, refs) {
+		t.Errorf("Expected referrers to be %v, got %v", refs, broken[0].Referrers)
+	}
+}
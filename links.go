@@ -0,0 +1,71 @@
+package geomi
+
+import (
+	"io"
+	"net/url"
+	"strings"
+)
+
+// The page regions a link can be in.
+const (
+	ContextNav     = "nav"
+	ContextHeader  = "header"
+	ContextFooter  = "footer"
+	ContextMain    = "main"
+	ContextAside   = "aside"
+	ContextArticle = "article"
+)
+
+// landmarkElements maps the landmark elements to the region they are.
+var landmarkElements = map[string]string{
+	"nav":     ContextNav,
+	"header":  ContextHeader,
+	"footer":  ContextFooter,
+	"main":    ContextMain,
+	"aside":   ContextAside,
+	"article": ContextArticle,
+}
+
+// landmarkRoles maps ARIA landmark roles to the region they are.
+var landmarkRoles = map[string]string{
+	"navigation":    ContextNav,
+	"banner":        ContextHeader,
+	"contentinfo":   ContextFooter,
+	"main":          ContextMain,
+	"complementary": ContextAside,
+	"article":       ContextArticle,
+}
+
+// Link is a link, an a or area element, on an html page.
+type Link struct {
+	URL      string   // the resolved href
+	Text     string   // the anchor text, or if there is none, the alt text of its images
+	Title    string   // the title attribute
+	Rel      []string // the rel values, lower cased
+	Target   string   // the target attribute, e.g. "_blank"
+	Context  string   // the region of the page the link is in, e.g. ContextNav; empty if it isn't in one
+	Position int      // the link's position on the page: 1 for the first link, 2 for the second, etc.
+}
+
+// Navigational returns whether the link is part of the site's navigation, i.e.
+// it's in a nav, header, or footer, rather than in the page's content.
+func (l Link) Navigational() bool {
+	return l.Context == ContextNav || l.Context == ContextHeader || l.Context == ContextFooter
+}
+
+// HasRel returns whether the link has the rel value, e.g. "nofollow".
+func (l Link) HasRel(rel string) bool {
+	for _, r := range l.Rel {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractLinks returns the links in the html read from r, in document order,
+// resolved against base. Links to just # are skipped; links to a fragment on the
+// page are kept so the fragment can be validated.
+func extractLinks(base *url.URL, r io.Reader) []Link {
+	return scanHTML(base, r, false).links
+}
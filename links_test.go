@@ -0,0 +1,85 @@
+package geomi
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// parseHTML parses the html, failing the test if it can't be.
+func parseHTML(t *testing.T, s string) *html.Node {
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestExtractLinks(t *testing.T) {
+	base, _ := url.Parse("http://golang.org/doc/")
+	body := `<header><a href="/">Home</a></header>
+<nav><ul><li><a href="/doc/" title=" Docs ">Documents</a></ul></nav>
+<main><p><a href="a.html">First  <b>link</b></a>
+<a href="#top">top</a><a href="#">#</a><a>no href</a>
+<a href="/pkg/" rel="NoFollow noopener" target="_blank"><img src="pkg.png" alt="Packages"></a>
+<a href="a.html">again</a></p>
+<map><area href="/map" alt="Map"></map></main>
+<div role="contentinfo"><a href="https://github.com/golang/go">GitHub</a></div>
+<a href="/loose">loose</a>`
+	expected := []Link{
+		{URL: "http://golang.org/", Text: "Home", Context: ContextHeader, Position: 1},
+		{URL: "http://golang.org/doc/", Text: "Documents", Title: "Docs", Context: ContextNav, Position: 2},
+		{URL: "http://golang.org/doc/a.html", Text: "First link", Context: ContextMain, Position: 3},
+		{URL: "http://golang.org/doc/#top", Text: "top", Context: ContextMain, Position: 4},
+		{URL: "http://golang.org/pkg/", Text: "Packages", Rel: []string{"nofollow", "noopener"}, Target: "_blank", Context: ContextMain, Position: 5},
+		{URL: "http://golang.org/doc/a.html", Text: "again", Context: ContextMain, Position: 6},
+		{URL: "http://golang.org/map", Text: "Map", Context: ContextMain, Position: 7},
+		{URL: "https://github.com/golang/go", Text: "GitHub", Context: ContextFooter, Position: 8},
+		{URL: "http://golang.org/loose", Text: "loose", Position: 9},
+	}
+	links := extractLinks(base, strings.NewReader(body))
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("Expected %d links:", len(expected))
+		for _, l := range expected {
+			t.Errorf("\t%+v", l)
+		}
+		t.Errorf("got %d:", len(links))
+		for _, l := range links {
+			t.Errorf("\t%+v", l)
+		}
+	}
+	if !links[0].Navigational() || links[2].Navigational() {
+		t.Error("Expected header links to be navigational and main links not to be")
+	}
+	if !links[4].HasRel("nofollow") || links[2].HasRel("nofollow") {
+		t.Error("Expected only the pkg link to be nofollow")
+	}
+}
+
+func TestCrawlLinks(t *testing.T) {
+	f := statusFetcher{
+		"http://golang.org/":     {`<nav><a href="/doc/">Docs</a></nav>`, ResponseInfo{StatusCode: 200, ContentType: "text/html"}, []string{"http://golang.org/doc/"}},
+		"http://golang.org/doc/": {`<a href="/">Home</a>`, ResponseInfo{StatusCode: 200, ContentType: "text/plain"}, nil},
+	}
+	docs := []Link{{URL: "http://golang.org/doc/", Text: "Docs", Context: ContextNav, Position: 1}}
+	tests := []struct {
+		extract bool
+		url     string
+		links   []Link
+	}{
+		// the links don't depend on the content being extracted
+		{true, "http://golang.org/", docs},
+		{false, "http://golang.org/", docs},
+		// a page that isn't html has no links
+		{false, "http://golang.org/doc/", nil},
+	}
+	for _, test := range tests {
+		s := crawledSpider(t, "http://golang.org/", AdaptFetcher(f), func(c *Config) { c.ExtractContent = test.extract })
+		if links := s.Pages[test.url].Links; !reflect.DeepEqual(links, test.links) {
+			t.Errorf("extract %t: %s: expected %+v, got %+v", test.extract, test.url, test.links, links)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package geomi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// mirrorAttrs are the attributes whose urls are rewritten in a mirrored html
+// page. srcset is a list of urls, style is css.
+var mirrorAttrs = map[string]bool{
+	"href":   true,
+	"src":    true,
+	"srcset": true,
+	"poster": true,
+	"style":  true,
+}
+
+// metaCharset matches the charset in a meta http-equiv Content-Type's content.
+var metaCharset = regexp.MustCompile(`(?i)(charset\s*=\s*)[^\s;"']+`)
+
+// cssCharset matches a stylesheet's @charset rule, which must be at its start.
+var cssCharset = regexp.MustCompile(`(?i)^@charset\s*["'][^"']*["']\s*;`)
+
+// Mirror writes every page that was crawled successfully to dir, in a tree that
+// mirrors the urls, e.g. http://example.com/doc/ is written to
+// dir/example.com/doc/index.html, so the site can be browsed offline. The links
+// in html pages and stylesheets to pages that were mirrored are rewritten to
+// the relative paths of their files; links to anything else are made absolute.
+// Html pages are written with the .html extension and a query is part of the
+// file name, after an @. A file whose path is also the directory of other
+// files is written in that directory, as index, with the file's extension, and
+// a path that's taken by another url gets a number before its extension, like
+// wget does. Bodies are written as they were kept, text is UTF-8; pages without
+// a body, e.g. because of DownloadNonHTML, aren't mirrored.
+//
+// A page that can't be written doesn't stop the others from being written; the
+// errors of every page that couldn't be are returned, joined.
+//
+// Mirror is called by Crawl when Config.MirrorDir is set.
+func (s *Spider) Mirror(dir string) error {
+	s.Lock()
+	defer s.Unlock()
+	paths := make(map[string]string) // the local path of each mirrored url
+	for k, p := range s.Pages {
+		r := s.fetchedURLs[k]
+		if r.Err != nil || r.StatusCode < 200 || r.StatusCode > 299 {
+			continue
+		}
+		// the body wasn't downloaded, or there's nothing to mirror
+		if body, err := p.Body(); err != nil || body == "" {
+			continue
+		}
+		paths[k] = mirrorPath(p.URL, r.ContentType)
+	}
+	keys := make([]string, 0, len(paths))
+	for k := range paths {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	uniquePaths(keys, paths)
+	var errs []error
+	for _, k := range keys {
+		if err := s.mirrorPage(dir, k, paths); err != nil {
+			errs = append(errs, fmt.Errorf("mirror %s: %w", k, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mirrorPage writes the page, with its links rewritten, to its path in dir.
+func (s *Spider) mirrorPage(dir, k string, paths map[string]string) error {
+	p := s.Pages[k]
+	r := s.fetchedURLs[k]
+	body, err := p.Body()
+	if err != nil {
+		return err
+	}
+	m := &mirror{base: p.URL, path: paths[k], paths: paths}
+	decoded := r.Charset != "" && !strings.EqualFold(r.Charset, "utf-8")
+	switch mt := mediaType(r.ContentType); {
+	case isHTML(mt):
+		body, err = m.html(body, decoded)
+		if err != nil {
+			return err
+		}
+	case mt == "text/css":
+		body = m.css(body)
+		// the stylesheet was decoded to UTF-8
+		if decoded {
+			body = cssCharset.ReplaceAllLiteralString(body, `@charset "utf-8";`)
+		}
+	}
+	name := filepath.Join(dir, filepath.FromSlash(paths[k]))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, []byte(body), 0644)
+}
+
+// uniquePaths changes the paths, of the urls in keys, so that no two urls have
+// the same path and no path is also a directory of other paths. A path that is
+// a directory becomes index, with the path's extension, in it; a path that's
+// taken gets a number before its extension. The keys are handled in order.
+func uniquePaths(keys []string, paths map[string]string) {
+	dirs := make(map[string]bool)
+	for _, p := range paths {
+		for d := path.Dir(p); d != "." && d != "/"; d = path.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	taken := make(map[string]bool, len(paths))
+	for _, k := range keys {
+		p := paths[k]
+		if dirs[p] {
+			p += "/index" + path.Ext(p)
+		}
+		if taken[p] {
+			ext := path.Ext(p)
+			stem := strings.TrimSuffix(p, ext)
+			for i := 1; taken[p] || dirs[p]; i++ {
+				p = fmt.Sprintf("%s.%d%s", stem, i, ext)
+			}
+		}
+		taken[p] = true
+		paths[k] = p
+	}
+}
+
+// mirrorPath returns the local path, relative to the mirror's dir and using
+// slashes, of the url. Directories are index.html.
+func mirrorPath(u *url.URL, contentType string) string {
+	// .. can't be used to write outside of the mirror
+	p := path.Clean("/" + u.Path)
+	if strings.HasSuffix(u.Path, "/") || p == "/" {
+		p = strings.TrimSuffix(p, "/") + "/index.html"
+	}
+	if u.RawQuery != "" {
+		ext := path.Ext(p)
+		q := strings.NewReplacer("/", "%2F", `\`, "%5C").Replace(u.RawQuery)
+		p = strings.TrimSuffix(p, ext) + "@" + q + ext
+	}
+	if isHTML(mediaType(contentType)) {
+		if ext := strings.ToLower(path.Ext(p)); ext != ".html" && ext != ".htm" {
+			p += ".html"
+		}
+	}
+	host := strings.ReplaceAll(u.Host, ":", "+")
+	return host + p
+}
+
+// mirror rewrites the links of a page being mirrored.
+type mirror struct {
+	base  *url.URL          // what links are resolved against: the page's url, or its base element's href
+	path  string            // the page's local path
+	paths map[string]string // the local paths of the mirrored urls
+}
+
+// link returns the ref, relative to the page, as it is in the mirror: the
+// relative path of the file, if the url was mirrored, otherwise the absolute
+// url.
+func (m *mirror) link(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return ref
+	}
+	u, err := m.base.Parse(ref)
+	if err != nil || !fetchable(u) {
+		return ref
+	}
+	frag := u.Fragment
+	u.Fragment = ""
+	p, ok := m.paths[u.String()]
+	if !ok {
+		u.Fragment = frag
+		return u.String()
+	}
+	rel, err := filepath.Rel(path.Dir(m.path), p)
+	if err != nil {
+		return ref
+	}
+	l := (&url.URL{Path: filepath.ToSlash(rel), Fragment: frag}).String()
+	// a relative path with a : in its first segment would be read as a scheme
+	if i := strings.IndexByte(l, ':'); i >= 0 && !strings.Contains(l[:i], "/") {
+		l = "./" + l
+	}
+	return l
+}
+
+// srcset returns the srcset with the urls of its candidates rewritten.
+func (m *mirror) srcset(v string) string {
+	cs := strings.Split(v, ",")
+	for i, c := range cs {
+		f := strings.Fields(c)
+		if len(f) == 0 {
+			continue
+		}
+		f[0] = m.link(f[0])
+		cs[i] = strings.Join(f, " ")
+	}
+	return strings.Join(cs, ", ")
+}
+
+// css returns the stylesheet with its url() and @import urls rewritten.
+func (m *mirror) css(s string) string {
+	for _, re := range []*regexp.Regexp{cssImport, cssURL} {
+		s = re.ReplaceAllStringFunc(s, func(match string) string {
+			sm := re.FindStringSubmatch(match)
+			ref := strings.Join(sm[1:], "")
+			if ref == "" || strings.HasPrefix(strings.ToLower(ref), "data:") {
+				return match
+			}
+			return strings.Replace(match, ref, m.link(ref), 1)
+		})
+	}
+	return s
+}
+
+// html returns the html page with its links rewritten. Only the tags that have
+// links are changed, everything else is written as it was. Links are resolved
+// against the page's base element, if it has an href; the href is removed, so
+// the rewritten links are relative to the file instead of the site. If the page
+// was decoded from another charset, its meta charset is changed to UTF-8.
+func (m *mirror) html(body string, decoded bool) (string, error) {
+	if href, ok := htmlBase(body); ok {
+		if u, err := m.base.Parse(strings.TrimSpace(href)); err == nil {
+			m.base = u
+		}
+	}
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(body))
+	var style bool // whether the text is in a style element
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); err != io.EOF {
+				return "", err
+			}
+			return b.String(), nil
+		}
+		raw := string(z.Raw())
+		switch tt {
+		case html.TextToken:
+			if style {
+				raw = m.css(raw)
+			}
+		case html.EndTagToken:
+			style = false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			style = t.Data == "style" && tt == html.StartTagToken
+			var changed bool
+			if t.Data == "base" {
+				attrs := t.Attr[:0]
+				for _, a := range t.Attr {
+					if a.Namespace == "" && a.Key == "href" {
+						changed = true
+						continue
+					}
+					attrs = append(attrs, a)
+				}
+				t.Attr = attrs
+			}
+			for i, a := range t.Attr {
+				v := a.Val
+				switch {
+				case a.Namespace != "" || !mirrorAttrs[a.Key]:
+					continue
+				case a.Key == "srcset":
+					v = m.srcset(v)
+				case a.Key == "style":
+					v = m.css(v)
+				default:
+					v = m.link(v)
+				}
+				if v != a.Val {
+					t.Attr[i].Val = v
+					changed = true
+				}
+			}
+			if decoded && t.Data == "meta" {
+				for i, a := range t.Attr {
+					switch a.Key {
+					case "charset":
+						t.Attr[i].Val = "utf-8"
+						changed = true
+					case "content":
+						if v := metaCharset.ReplaceAllString(a.Val, "${1}utf-8"); v != a.Val {
+							t.Attr[i].Val = v
+							changed = true
+						}
+					}
+				}
+			}
+			if changed {
+				raw = t.String()
+			}
+		}
+		b.WriteString(raw)
+	}
+}
+
+// htmlBase returns the href of the page's first base element that has one, and
+// whether there is one.
+func htmlBase(body string) (string, bool) {
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return "", false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "base" {
+				continue
+			}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == "href" {
+					return string(val), true
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package geomi
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMirrorPath(t *testing.T) {
+	tests := []struct {
+		url         string
+		contentType string
+		expected    string
+	}{
+		{"http://golang.org", "text/html", "golang.org/index.html"},
+		{"http://golang.org/doc/", "text/html", "golang.org/doc/index.html"},
+		{"http://golang.org/doc/a", "text/html; charset=utf-8", "golang.org/doc/a.html"},
+		{"http://golang.org/doc/a.htm", "text/html", "golang.org/doc/a.htm"},
+		{"http://golang.org/doc/style.css", "text/css", "golang.org/doc/style.css"},
+		{"http://golang.org/doc/a.png?v=2", "image/png", "golang.org/doc/a@v=2.png"},
+		{"http://golang.org/search?q=a/b", "text/html", "golang.org/search@q=a%2Fb.html"},
+		{"http://golang.org:8080/doc/../../../etc/passwd", "text/plain", "golang.org+8080/etc/passwd"},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		if p := mirrorPath(u, test.contentType); p != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.url, test.expected, p)
+		}
+	}
+}
+
+func TestMirror(t *testing.T) {
+	html := ResponseInfo{StatusCode: 200, ContentType: "text/html"}
+	css := ResponseInfo{StatusCode: 200, ContentType: "text/css"}
+	f := statusFetcher{
+		"http://golang.org/doc/": {
+			`<html><head><meta charset="shift_jis"><link rel="stylesheet" href="css/style.css"><style>body { background: url("img/bg.png") }</style></head>
+<body><!-- <a href="a"> --><a href="a#install">A</a> <a href="/pkg/">pkg</a> <a href="https://github.com/golang/go">go</a> <a href="mailto:gopher@golang.org">mail</a>
+<img src="img/bg.png" srcset="img/bg.png 1x, img/missing.png 2x"><a href="gone">gone</a></body></html>`,
+			ResponseInfo{StatusCode: 200, ContentType: "text/html; charset=shift_jis", Charset: "shift_jis"},
+			[]string{"http://golang.org/doc/a", "http://golang.org/doc/css/style.css", "http://golang.org/doc/img/bg.png", "http://golang.org/doc/gone", "http://golang.org/pkg/"},
+		},
+		"http://golang.org/doc/a":             {`<a href="./">back</a>`, html, []string{"http://golang.org/doc/"}},
+		"http://golang.org/doc/css/style.css": {`@import "print.css"; h1 { background: url(../img/bg.png) } p { background: url('data:image/png;base64,AA==') }`, css, nil},
+		"http://golang.org/doc/img/bg.png":    {"\x89PNG", ResponseInfo{StatusCode: 200, ContentType: "image/png"}, nil},
+	}
+	dir := t.TempDir()
+	crawledSpider(t, "http://golang.org/doc/", AdaptFetcher(f), func(c *Config) { c.MirrorDir = dir })
+	tests := []struct {
+		file     string
+		expected []string // what the file must contain
+	}{
+		{"golang.org/doc/index.html", []string{
+			`<meta charset="utf-8">`,
+			`<link rel="stylesheet" href="css/style.css">`,
+			`url("img/bg.png")`,
+			`<!-- <a href="a"> -->`,
+			`<a href="a.html#install">A</a>`,
+			`<a href="http://golang.org/pkg/">pkg</a>`,
+			`<a href="https://github.com/golang/go">go</a>`,
+			`<a href="mailto:gopher@golang.org">mail</a>`,
+			`srcset="img/bg.png 1x, http://golang.org/doc/img/missing.png 2x"`,
+			`<a href="http://golang.org/doc/gone">gone</a>`,
+		}},
+		{"golang.org/doc/a.html", []string{`<a href="index.html">back</a>`}},
+		{"golang.org/doc/css/style.css", []string{`@import "http://golang.org/doc/css/print.css"`, `url(../img/bg.png)`, `url('data:image/png;base64,AA==')`}},
+		{"golang.org/doc/img/bg.png", []string{"\x89PNG"}},
+	}
+	for _, test := range tests {
+		b, err := os.ReadFile(filepath.Join(dir, test.file))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		for _, s := range test.expected {
+			if !strings.Contains(string(b), s) {
+				t.Errorf("%s: expected it to contain %s, got %s", test.file, s, b)
+			}
+		}
+	}
+	// the 404 isn't mirrored
+	if _, err := os.Stat(filepath.Join(dir, "golang.org/doc/gone.html")); !os.IsNotExist(err) {
+		t.Errorf("Expected gone not to be mirrored, got %v", err)
+	}
+}
+
+func TestUniquePaths(t *testing.T) {
+	paths := map[string]string{
+		"http://golang.org/doc/feed":          "golang.org/doc/feed",
+		"http://golang.org/doc/feed/atom.xml": "golang.org/doc/feed/atom.xml",
+		"http://golang.org/doc/a.png?v=2":     "golang.org/doc/a@v=2.png",
+		"http://golang.org/doc/a@v=2.png":     "golang.org/doc/a@v=2.png",
+		"http://golang.org/doc/style.css":     "golang.org/doc/style.css",
+		"http://golang.org/doc/style.css/x":   "golang.org/doc/style.css/x",
+	}
+	expected := map[string]string{
+		"http://golang.org/doc/feed":          "golang.org/doc/feed/index",
+		"http://golang.org/doc/feed/atom.xml": "golang.org/doc/feed/atom.xml",
+		"http://golang.org/doc/a.png?v=2":     "golang.org/doc/a@v=2.png",
+		"http://golang.org/doc/a@v=2.png":     "golang.org/doc/a@v=2.1.png",
+		"http://golang.org/doc/style.css":     "golang.org/doc/style.css/index.css",
+		"http://golang.org/doc/style.css/x":   "golang.org/doc/style.css/x",
+	}
+	keys := make([]string, 0, len(paths))
+	for k := range paths {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	uniquePaths(keys, paths)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+}
+
+func TestMirrorBase(t *testing.T) {
+	html := ResponseInfo{StatusCode: 200, ContentType: "text/html"}
+	f := statusFetcher{
+		"http://golang.org/": {`<a href="doc/">doc</a>`, html, []string{"http://golang.org/doc/"}},
+		"http://golang.org/doc/": {
+			`<html><head><a href="a">before</a><base href="/pkg/" target="_top"><link rel="stylesheet" href="/doc/style.css"></head><body><a href="fmt/">fmt</a> <a href="/doc/a">a</a> <a href="io/">io</a></body></html>`,
+			html,
+			[]string{"http://golang.org/doc/a", "http://golang.org/doc/style.css", "http://golang.org/pkg/a", "http://golang.org/pkg/fmt/"},
+		},
+		"http://golang.org/doc/a":         {"A", html, nil},
+		"http://golang.org/pkg/a":         {"pkg A", html, nil},
+		"http://golang.org/pkg/fmt/":      {"fmt", html, nil},
+		"http://golang.org/doc/style.css": {`@charset "shift_jis"; h1 { background: url(bg.png) }`, ResponseInfo{StatusCode: 200, ContentType: "text/css; charset=shift_jis", Charset: "shift_jis"}, nil},
+	}
+	dir := t.TempDir()
+	crawledSpider(t, "http://golang.org/", AdaptFetcher(f), func(c *Config) { c.MirrorDir = dir })
+	tests := []struct {
+		file     string
+		expected []string // what the file must contain
+	}{
+		{"golang.org/doc/index.html", []string{
+			// the base applies to the whole page
+			`<a href="../pkg/a.html">before</a>`,
+			`<base target="_top">`,
+			`<link rel="stylesheet" href="style.css">`,
+			`<a href="../pkg/fmt/index.html">fmt</a>`,
+			`<a href="a.html">a</a>`,
+			`<a href="http://golang.org/pkg/io/">io</a>`,
+		}},
+		{"golang.org/doc/style.css", []string{`@charset "utf-8"; h1 { background: url(http://golang.org/doc/bg.png) }`}},
+	}
+	for _, test := range tests {
+		b, err := os.ReadFile(filepath.Join(dir, test.file))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		for _, s := range test.expected {
+			if !strings.Contains(string(b), s) {
+				t.Errorf("%s: expected it to contain %s, got %s", test.file, s, b)
+			}
+		}
+	}
+}
+
+func TestMirrorCollisions(t *testing.T) {
+	f := statusFetcher{
+		"http://golang.org/doc/": {
+			`<a href="feed">feed</a> <a href="feed/atom.xml">atom</a> <a href="img/bg.png">bg</a>`,
+			ResponseInfo{StatusCode: 200, ContentType: "text/html"},
+			[]string{"http://golang.org/doc/feed", "http://golang.org/doc/feed/atom.xml", "http://golang.org/doc/img/bg.png"},
+		},
+		"http://golang.org/doc/feed":          {"<feed/>", ResponseInfo{StatusCode: 200, ContentType: "application/atom+xml"}, nil},
+		"http://golang.org/doc/feed/atom.xml": {"<feed/>", ResponseInfo{StatusCode: 200, ContentType: "application/atom+xml"}, nil},
+		"http://golang.org/doc/img/bg.png":    {"\x89PNG", ResponseInfo{StatusCode: 200, ContentType: "image/png"}, nil},
+	}
+	s := crawledSpider(t, "http://golang.org/doc/", AdaptFetcher(f))
+	dir := t.TempDir()
+	// the png can't be written where a directory is
+	if err := os.MkdirAll(filepath.Join(dir, "golang.org/doc/img/bg.png"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Mirror(dir)
+	if err == nil || !strings.Contains(err.Error(), "mirror http://golang.org/doc/img/bg.png: ") {
+		t.Errorf("Expected the png's error, got %v", err)
+	}
+	tests := []struct {
+		file     string
+		expected string
+	}{
+		{"golang.org/doc/feed/index", "<feed/>"},
+		{"golang.org/doc/feed/atom.xml", "<feed/>"},
+		{"golang.org/doc/index.html", `<a href="feed/index">feed</a> <a href="feed/atom.xml">atom</a>`},
+	}
+	for _, test := range tests {
+		b, err := os.ReadFile(filepath.Join(dir, test.file))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !strings.Contains(string(b), test.expected) {
+			t.Errorf("%s: expected it to contain %s, got %s", test.file, test.expected, b)
+		}
+	}
+}
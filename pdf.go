@@ -0,0 +1,258 @@
+package geomi
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"unicode/utf16"
+)
+
+// PDFHandler is the ContentHandler for application/pdf. It returns the links
+// from the document's URI actions, e.g. link annotations, and adds the
+// document's title, author, and page count, when known, to the response's Meta
+// as "title", "author", and "pages".
+//
+// This isn't a full pdf parser: it looks at the objects in the file and in any
+// flate compressed object streams, which is where that information lives. An
+// encrypted pdf will have no links.
+var PDFHandler ContentHandler = ContentHandlerFunc(handlePDF)
+
+// defaultHandlers are the content handlers used when a media type doesn't have a
+// handler or extractor registered.
+var defaultHandlers = map[string]ContentHandler{
+	"application/pdf": PDFHandler,
+}
+
+var (
+	pdfObj     = regexp.MustCompile(`(?s)(\d+)\s+(\d+)\s+obj\b(.*?)\bendobj`)
+	pdfStream  = regexp.MustCompile(`(?s)^(.*?)stream\r?\n(.*)endstream\s*$`)
+	pdfURI     = regexp.MustCompile(`/URI\s*([(<])`)
+	pdfInfo    = regexp.MustCompile(`/Info\s+(\d+)\s+\d+\s+R`)
+	pdfPages   = regexp.MustCompile(`/Type\s*/Pages\b`)
+	pdfCount   = regexp.MustCompile(`/Count\s+(\d+)`)
+	pdfObjStm  = regexp.MustCompile(`/Type\s*/ObjStm\b`)
+	pdfFirst   = regexp.MustCompile(`/First\s+(\d+)`)
+	pdfFlate   = regexp.MustCompile(`/FlateDecode\b`)
+	pdfTitle   = regexp.MustCompile(`/Title\s*([(<])`)
+	pdfAuthor  = regexp.MustCompile(`/Author\s*([(<])`)
+	pdfHeader  = []byte("%PDF-")
+	pdfNumbers = regexp.MustCompile(`\d+`)
+)
+
+// handlePDF keeps the body, extracting its links and metadata.
+func handlePDF(base *url.URL, body io.Reader, r *ResponseInfo) (string, []string, error) {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return "", nil, err
+	}
+	if !bytes.HasPrefix(bytes.TrimLeft(b, "\x00\t\n\f\r "), pdfHeader) {
+		return string(b), nil, nil
+	}
+	objs := pdfObjects(b)
+	var refs []string
+	var pages int
+	for _, o := range objs {
+		for _, m := range pdfURI.FindAllSubmatchIndex(o, -1) {
+			if s, ok := pdfString(o[m[2]:]); ok {
+				refs = append(refs, s)
+			}
+		}
+		if pdfPages.Match(o) {
+			if m := pdfCount.FindSubmatch(o); m != nil {
+				// the root of the page tree has the highest count
+				if n, _ := strconv.Atoi(string(m[1])); n > pages {
+					pages = n
+				}
+			}
+		}
+	}
+	meta := make(map[string]string)
+	if pages > 0 {
+		meta["pages"] = strconv.Itoa(pages)
+	}
+	// the last Info is the current one
+	if m := pdfInfo.FindAllSubmatch(b, -1); m != nil {
+		n, _ := strconv.Atoi(string(m[len(m)-1][1]))
+		if info, ok := objs[n]; ok {
+			for k, re := range map[string]*regexp.Regexp{"title": pdfTitle, "author": pdfAuthor} {
+				if m := re.FindSubmatchIndex(info); m != nil {
+					if s, ok := pdfString(info[m[2]:]); ok && s != "" {
+						meta[k] = s
+					}
+				}
+			}
+		}
+	}
+	if len(meta) > 0 {
+		r.Meta = meta
+	}
+	return string(b), resolve(base, refs), nil
+}
+
+// pdfObjects returns the contents of the pdf's objects by object number,
+// including those in object streams; streams are reduced to their dictionary.
+// When an object is defined more than once, as happens with incremental updates,
+// the last definition is used.
+func pdfObjects(b []byte) map[int][]byte {
+	objs := make(map[int][]byte)
+	for _, m := range pdfObj.FindAllSubmatch(b, -1) {
+		n, _ := strconv.Atoi(string(m[1]))
+		// only the dictionary of a stream is kept, its data could match anything
+		o := m[3]
+		if sm := pdfStream.FindSubmatch(o); sm != nil {
+			o = sm[1]
+		}
+		objs[n] = o
+		if !pdfObjStm.Match(o) {
+			continue
+		}
+		for k, v := range pdfObjStmObjects(m[3]) {
+			objs[k] = v
+		}
+	}
+	return objs
+}
+
+// pdfObjStmObjects returns the objects in an object stream. The stream starts
+// with pairs of object number and offset, the offsets are relative to /First.
+func pdfObjStmObjects(o []byte) map[int][]byte {
+	sm := pdfStream.FindSubmatch(o)
+	if sm == nil {
+		return nil
+	}
+	dict, data := sm[1], sm[2]
+	if pdfFlate.Match(dict) {
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil
+		}
+		// a trailing checksum error still leaves usable data
+		data, _ = io.ReadAll(zr)
+	}
+	fm := pdfFirst.FindSubmatch(dict)
+	if fm == nil {
+		return nil
+	}
+	first, _ := strconv.Atoi(string(fm[1]))
+	if first > len(data) {
+		return nil
+	}
+	nums := pdfNumbers.FindAll(data[:first], -1)
+	objs := make(map[int][]byte)
+	for i := 0; i+1 < len(nums); i += 2 {
+		n, _ := strconv.Atoi(string(nums[i]))
+		start, _ := strconv.Atoi(string(nums[i+1]))
+		end := len(data) - first
+		if i+3 < len(nums) {
+			end, _ = strconv.Atoi(string(nums[i+3]))
+		}
+		if start > end || first+end > len(data) {
+			continue
+		}
+		objs[n] = data[first+start : first+end]
+	}
+	return objs
+}
+
+// pdfString decodes the literal, (...), or hex, <...>, string at the start of b.
+// Strings starting with a UTF-16BE byte order mark are decoded as such,
+// otherwise each byte is a character.
+func pdfString(b []byte) (string, bool) {
+	if len(b) == 0 {
+		return "", false
+	}
+	var s []byte
+	switch b[0] {
+	case '(':
+		depth := 0
+	literal:
+		for i := 0; i < len(b); i++ {
+			c := b[i]
+			switch c {
+			case '(':
+				depth++
+				if depth == 1 {
+					continue
+				}
+			case ')':
+				depth--
+				if depth == 0 {
+					break literal
+				}
+			case '\\':
+				i++
+				if i == len(b) {
+					return "", false
+				}
+				switch c = b[i]; c {
+				case 'n':
+					c = '\n'
+				case 'r':
+					c = '\r'
+				case 't':
+					c = '\t'
+				case 'b':
+					c = '\b'
+				case 'f':
+					c = '\f'
+				case '\r', '\n':
+					// line continuation
+					if c == '\r' && i+1 < len(b) && b[i+1] == '\n' {
+						i++
+					}
+					continue
+				case '0', '1', '2', '3', '4', '5', '6', '7':
+					j := i
+					for j < len(b) && j < i+3 && b[j] >= '0' && b[j] <= '7' {
+						j++
+					}
+					v, _ := strconv.ParseUint(string(b[i:j]), 8, 8)
+					c = byte(v)
+					i = j - 1
+				}
+			}
+			s = append(s, c)
+		}
+		if depth != 0 {
+			return "", false
+		}
+	case '<':
+		end := bytes.IndexByte(b, '>')
+		if end < 0 {
+			return "", false
+		}
+		var hex []byte
+		for _, c := range b[1:end] {
+			if c > ' ' {
+				hex = append(hex, c)
+			}
+		}
+		if len(hex)%2 == 1 {
+			hex = append(hex, '0')
+		}
+		for i := 0; i < len(hex); i += 2 {
+			v, err := strconv.ParseUint(string(hex[i:i+2]), 16, 8)
+			if err != nil {
+				return "", false
+			}
+			s = append(s, byte(v))
+		}
+	default:
+		return "", false
+	}
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		u := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			u = append(u, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(u)), true
+	}
+	r := make([]rune, len(s))
+	for i, c := range s {
+		r[i] = rune(c)
+	}
+	return string(r), true
+}
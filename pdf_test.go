@@ -0,0 +1,139 @@
+package geomi
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const plainPDF = `%PDF-1.4
+1 0 obj
+<< /Type /Catalog /Pages 2 0 R >>
+endobj
+2 0 obj
+<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>
+endobj
+3 0 obj
+<< /Type /Page /Parent 2 0 R /Annots [5 0 R 6 0 R] >>
+endobj
+4 0 obj
+<< /Type /Page /Parent 2 0 R >>
+endobj
+5 0 obj
+<< /Type /Annot /Subtype /Link /A << /S /URI /URI (http://example.com/a\(1\)) >> >>
+endobj
+6 0 obj
+<< /Type /Annot /Subtype /Link /A << /S /URI /URI <2F72656C> >> >>
+endobj
+7 0 obj
+<< /Title (Plain \050text\051) /Author <FEFF00C90076006500> >>
+endobj
+trailer
+<< /Root 1 0 R /Info 7 0 R >>
+%%EOF
+`
+
+// objStmPDF returns a pdf whose annotation and info objects are in a flate
+// compressed object stream.
+func objStmPDF() string {
+	objs := []string{
+		"<< /Type /Annot /Subtype /Link /A << /S /URI /URI (https://example.org/packed) >> >>",
+		"<< /Title (Packed) >>",
+	}
+	header := fmt.Sprintf("10 0 11 %d ", len(objs[0]))
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write([]byte(header + strings.Join(objs, "")))
+	w.Close()
+	return fmt.Sprintf("%%PDF-1.5\n"+
+		"1 0 obj\n<< /Type /Pages /Count 3 >>\nendobj\n"+
+		"2 0 obj\n<< /Type /ObjStm /N 2 /First %d /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream\nendobj\n"+
+		"3 0 obj\n<< /Type /XRef /Root 1 0 R /Info 11 0 R >>\nstream\n\nendstream\nendobj\n%%%%EOF\n",
+		len(header), z.Len(), z.String())
+}
+
+func TestHandlePDF(t *testing.T) {
+	base, _ := url.Parse("http://example.com/docs/a.pdf")
+	tests := []struct {
+		name string
+		body string
+		urls []string
+		meta map[string]string
+	}{
+		{"plain", plainPDF, []string{"http://example.com/a(1)", "http://example.com/rel"}, map[string]string{"title": "Plain (text)", "author": "Éve", "pages": "2"}},
+		{"object stream", objStmPDF(), []string{"https://example.org/packed"}, map[string]string{"title": "Packed", "pages": "3"}},
+		{"not a pdf", "<html></html>", nil, nil},
+	}
+	for _, test := range tests {
+		var r ResponseInfo
+		body, urls, err := handlePDF(base, strings.NewReader(test.body), &r)
+		if err != nil {
+			t.Errorf("%s: expected no error, got %q", test.name, err)
+			continue
+		}
+		if body != test.body {
+			t.Errorf("%s: expected the body to be kept", test.name)
+		}
+		// objects aren't in file order
+		sort.Strings(urls)
+		if !reflect.DeepEqual(urls, test.urls) {
+			t.Errorf("%s: expected urls %v, got %v", test.name, test.urls, urls)
+		}
+		if !reflect.DeepEqual(r.Meta, test.meta) {
+			t.Errorf("%s: expected meta %v, got %v", test.name, test.meta, r.Meta)
+		}
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+		ok       bool
+	}{
+		{"(abc) trailing", "abc", true},
+		{`(a (nested) b)`, "a (nested) b", true},
+		{`(esc\n\t\\\)\101)`, "esc\n\t\\)A", true},
+		{"(line\\\ncontinued)", "linecontinued", true},
+		{"<48 69 7>", "Hip", true},
+		{"<FEFF0048>", "H", true},
+		{"(unterminated", "", false},
+		{"<zz>", "", false},
+		{"/Name", "", false},
+	}
+	for _, test := range tests {
+		s, ok := pdfString([]byte(test.s))
+		if s != test.expected || ok != test.ok {
+			t.Errorf("%q: expected %q, %t, got %q, %t", test.s, test.expected, test.ok, s, ok)
+		}
+	}
+}
+
+func TestFetchPDF(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		io.WriteString(w, plainPDF)
+	}))
+	defer ts.Close()
+	c := NewConfig()
+	c.DownloadNonHTML = false
+	s := Site{Config: c}
+	_, r, urls := s.Fetch(ts.URL + "/docs/a.pdf")
+	if r.Err != nil {
+		t.Fatalf("expected no error, got %q", r.Err)
+	}
+	if len(urls) != 2 {
+		t.Errorf("expected 2 urls, got %v", urls)
+	}
+	if r.Meta["pages"] != "2" {
+		t.Errorf("expected 2 pages, got %q", r.Meta["pages"])
+	}
+}
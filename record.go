@@ -0,0 +1,509 @@
+package geomi
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// ArchiveVersion is the version of HAR written by a Recorder.
+const ArchiveVersion = "1.2"
+
+// ErrNotArchived is the error of a replayed url that isn't in the archive.
+var ErrNotArchived = errors.New("the url isn't in the archive")
+
+// The archive is a HAR, http://www.softwareishard.com/blog/har-12-spec/, with
+// what geomi needs to replay a crawl, that HAR doesn't have, in fields that start
+// with an _, as HAR allows.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	Started  time.Time   `json:"startedDateTime"`
+	Time     float64     `json:"time"` // milliseconds
+	Request  harRequest  `json:"request"`
+	Response harResponse `json:"response"`
+	Cache    struct{}    `json:"cache"`
+	Timings  harTimings  `json:"timings"`
+	URLs     []string    `json:"_urls,omitempty"` // the links found in the body
+	Kind     string      `json:"_kind,omitempty"` // what made the request, if not the fetcher: "robots" or "external"
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []harCookie `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	HeadersSize int         `json:"headersSize"` // -1, the headers sent aren't known
+	BodySize    int         `json:"bodySize"`
+	Depth       int         `json:"_depth"`
+	Referrer    string      `json:"_referrer,omitempty"`
+}
+
+type harResponse struct {
+	Status          int               `json:"status"`
+	StatusText      string            `json:"statusText"`
+	HTTPVersion     string            `json:"httpVersion"`
+	Cookies         []harCookie       `json:"cookies"`
+	Headers         []harHeader       `json:"headers"`
+	Content         harContent        `json:"content"`
+	RedirectURL     string            `json:"redirectURL"`
+	HeadersSize     int               `json:"headersSize"` // -1, the headers received aren't known
+	BodySize        int64             `json:"bodySize"`    // the bytes received, -1 if unknown
+	ContentLength   int64             `json:"_contentLength"`
+	ContentEncoding string            `json:"_contentEncoding,omitempty"`
+	Charset         string            `json:"_charset,omitempty"`
+	Truncated       bool              `json:"_truncated,omitempty"`
+	Meta            map[string]string `json:"_meta,omitempty"`
+	Error           string            `json:"_error,omitempty"`
+	ErrorReason     string            `json:"_errorReason,omitempty"` // how the error is classified, e.g. BrokenTimeout
+	Redirects       []Redirect        `json:"_redirects,omitempty"`
+}
+
+// harHeader is a header, or a query string parameter.
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"` // base64, if the body isn't UTF-8
+}
+
+// harTimings are in milliseconds; what geomi doesn't know is left out, as HAR
+// allows, so the time until the headers were received is all wait.
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// exchanger records, or replays, the requests a crawl makes that aren't made by
+// its fetcher: the robots.txt and the external link checks. do makes the request.
+type exchanger interface {
+	exchange(kind, method, url string, do func() Result) Result
+}
+
+// Recorder is a RequestFetcher that records every exchange of its fetcher, so the
+// crawl can be replayed with a Replayer, e.g. to reproduce a bug, as a test
+// fixture, or to analyze a site without crawling it again. When the crawl is
+// made with CrawlWith, its robots.txt and external link checks are recorded
+// too. Each exchange is
+// written to the archive as it's made, so the bodies aren't kept in memory; the
+// archive is complete once the Recorder is closed.
+type Recorder struct {
+	Fetcher RequestFetcher
+	mu      sync.Mutex
+	w       io.Writer
+	c       io.Closer // the file written to, if the Recorder created it
+	entries int       // the number of entries written
+	err     error     // the first error writing the archive
+}
+
+// NewRecorder returns a Recorder that records the exchanges of the fetcher to
+// w, in the order they were made.
+func NewRecorder(f RequestFetcher, w io.Writer) *Recorder {
+	rec := &Recorder{Fetcher: f, w: w}
+	creator, err := json.Marshal(harCreator{Name: "geomi", Version: geomiVersion()})
+	if err != nil {
+		rec.err = err
+		return rec
+	}
+	_, rec.err = fmt.Fprintf(w, "{\"log\":{\"version\":%q,\"creator\":%s,\"entries\":[\n", ArchiveVersion, creator)
+	return rec
+}
+
+// CreateRecorder returns a Recorder that records the exchanges of the fetcher
+// to the file, which is created, or truncated.
+func CreateRecorder(f RequestFetcher, name string) (*Recorder, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	rec := NewRecorder(f, file)
+	rec.c = file
+	return rec, nil
+}
+
+// geomiVersion returns the version of the geomi module the program was built
+// with, or "(devel)" if it isn't known.
+func geomiVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if bi.Main.Path == "github.com/mohae/geomi" && bi.Main.Version != "" {
+		return bi.Main.Version
+	}
+	for _, m := range bi.Deps {
+		if m.Path == "github.com/mohae/geomi" {
+			return m.Version
+		}
+	}
+	return "(devel)"
+}
+
+// FetchRequest implements RequestFetcher. The request is fetched by the
+// Recorder's fetcher and the exchange is written to the archive. An error
+// writing the archive doesn't affect the result, it's returned by Close.
+func (rec *Recorder) FetchRequest(req Request) Result {
+	start := time.Now()
+	res := rec.Fetcher.FetchRequest(req)
+	rec.write(newHAREntry("", "GET", req, start, res))
+	return res
+}
+
+// exchange implements exchanger. The request is made and the exchange is written
+// to the archive.
+func (rec *Recorder) exchange(kind, method, url string, do func() Result) Result {
+	start := time.Now()
+	res := do()
+	rec.write(newHAREntry(kind, method, Request{URL: url}, start, res))
+	return res
+}
+
+// newHAREntry returns the entry of an exchange that started at start.
+func newHAREntry(kind, method string, req Request, start time.Time, res Result) harEntry {
+	e := harEntry{
+		Started: start,
+		Time:    float64(time.Since(start)) / float64(time.Millisecond),
+		URLs:    res.URLs,
+		Kind:    kind,
+	}
+	r := res.ResponseInfo
+	e.Request = harRequest{
+		Method:      method,
+		URL:         req.URL,
+		HTTPVersion: r.Proto,
+		Cookies:     harCookies((&http.Request{Header: req.Header}).Cookies()),
+		Headers:     harHeaders(req.Header),
+		QueryString: harQuery(req.URL),
+		HeadersSize: -1,
+		Depth:       req.Depth,
+		Referrer:    req.Referrer,
+	}
+	e.Response = harResponse{
+		Status:          r.StatusCode,
+		StatusText:      strings.TrimSpace(strings.TrimPrefix(r.Status, strconv.Itoa(r.StatusCode))),
+		HTTPVersion:     r.Proto,
+		Cookies:         harCookies((&http.Response{Header: r.Header}).Cookies()),
+		Headers:         harHeaders(r.Header),
+		Content:         harContent{Size: r.BodyBytes, MimeType: r.ContentType},
+		RedirectURL:     r.Header.Get("Location"),
+		HeadersSize:     -1,
+		BodySize:        -1,
+		ContentLength:   r.ContentLength,
+		ContentEncoding: r.ContentEncoding,
+		Charset:         r.Charset,
+		Truncated:       r.Truncated,
+		Meta:            r.Meta,
+		Redirects:       r.Redirects,
+	}
+	e.Timings.Wait = float64(r.FirstByte) / float64(time.Millisecond)
+	if e.Timings.Wait > e.Time {
+		e.Timings.Wait = e.Time
+	}
+	e.Timings.Receive = e.Time - e.Timings.Wait
+	// fetchers that don't count what they receive have the body's size
+	if e.Response.Content.Size == 0 {
+		e.Response.Content.Size = int64(len(res.Body))
+	}
+	if r.CompressedBytes > 0 {
+		e.Response.BodySize = r.CompressedBytes
+	}
+	if r.Err != nil {
+		e.Response.Error = r.Err.Error()
+		e.Response.ErrorReason = errorReason(r.Err)
+	}
+	if utf8.ValidString(res.Body) {
+		e.Response.Content.Text = res.Body
+	} else {
+		e.Response.Content.Text = base64.StdEncoding.EncodeToString([]byte(res.Body))
+		e.Response.Content.Encoding = "base64"
+	}
+	return e
+}
+
+// write writes the entry to the archive, unless writing has failed.
+func (rec *Recorder) write(e harEntry) {
+	b, err := json.Marshal(e)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.err != nil {
+		return
+	}
+	if err != nil {
+		rec.err = err
+		return
+	}
+	if rec.entries > 0 {
+		if _, rec.err = io.WriteString(rec.w, ",\n"); rec.err != nil {
+			return
+		}
+	}
+	_, rec.err = rec.w.Write(b)
+	rec.entries++
+}
+
+// Close finishes the archive, closing the file if the Recorder created it. The
+// first error writing the archive is returned.
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.err == nil {
+		_, rec.err = io.WriteString(rec.w, "\n]}}\n")
+	}
+	err := rec.err
+	if rec.c != nil {
+		if cerr := rec.c.Close(); err == nil {
+			err = cerr
+		}
+		rec.c = nil
+	}
+	if rec.err == nil {
+		rec.err = errors.New("record: the archive is closed")
+	}
+	return err
+}
+
+// harHeaders returns the headers in HAR form, sorted by name.
+func harHeaders(h http.Header) []harHeader {
+	hs := []harHeader{}
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			hs = append(hs, harHeader{k, v})
+		}
+	}
+	return hs
+}
+
+// harQuery returns the query string parameters of the url, in the order they
+// are in the url.
+func harQuery(rawURL string) []harHeader {
+	qs := []harHeader{}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return qs
+	}
+	for _, p := range strings.Split(u.RawQuery, "&") {
+		if p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		k, v := kv[0], ""
+		if len(kv) == 2 {
+			v = kv[1]
+		}
+		if uk, err := url.QueryUnescape(k); err == nil {
+			k = uk
+		}
+		if uv, err := url.QueryUnescape(v); err == nil {
+			v = uv
+		}
+		qs = append(qs, harHeader{k, v})
+	}
+	return qs
+}
+
+// harCookies returns the cookies in HAR form.
+func harCookies(cookies []*http.Cookie) []harCookie {
+	cs := []harCookie{}
+	for _, c := range cookies {
+		hc := harCookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain, HTTPOnly: c.HttpOnly, Secure: c.Secure}
+		if !c.Expires.IsZero() {
+			expires := c.Expires
+			hc.Expires = &expires
+		}
+		cs = append(cs, hc)
+	}
+	return cs
+}
+
+// Replayer is a RequestFetcher that serves a crawl from an archive written by a
+// Recorder; nothing is requested over the network. A url that was fetched more
+// than once, e.g. because it was retried, is replayed in the order it was
+// recorded, with the last exchange being repeated once they've all been
+// replayed. When the crawl is made with CrawlWith, its robots.txt and external
+// link checks are replayed too; one that wasn't recorded fails with
+// ErrNotArchived, instead of being requested. Errors are replayed with their message and classify the way the
+// recorded errors did, so they're retried, and reported, the same.
+type Replayer struct {
+	mu      sync.Mutex
+	entries map[replayKey][]harEntry
+}
+
+// replayKey is what a recorded exchange is looked up by.
+type replayKey struct {
+	kind   string
+	method string
+	url    string
+}
+
+// NewReplayer returns a Replayer for the archive read from r.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	var f harFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	rp := &Replayer{entries: make(map[replayKey][]harEntry)}
+	for _, e := range f.Log.Entries {
+		k := replayKey{e.Kind, e.Request.Method, e.Request.URL}
+		rp.entries[k] = append(rp.entries[k], e)
+	}
+	return rp, nil
+}
+
+// LoadReplayer returns a Replayer for the archive in the file.
+func LoadReplayer(name string) (*Replayer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewReplayer(f)
+}
+
+// FetchRequest implements RequestFetcher. Only the request's url is used to
+// find the exchange that's replayed. If the url isn't in the archive, the
+// result's Err is ErrNotArchived.
+func (rp *Replayer) FetchRequest(req Request) Result {
+	return rp.replay(replayKey{"", "GET", req.URL})
+}
+
+// exchange implements exchanger. The recorded exchange is replayed; do is never
+// called.
+func (rp *Replayer) exchange(kind, method, url string, do func() Result) Result {
+	return rp.replay(replayKey{kind, method, url})
+}
+
+// replay returns the next recorded exchange for the key.
+func (rp *Replayer) replay(k replayKey) Result {
+	rp.mu.Lock()
+	es := rp.entries[k]
+	if len(es) == 0 {
+		rp.mu.Unlock()
+		return Result{ResponseInfo: ResponseInfo{Err: fmt.Errorf("replay %s: %w", k.url, ErrNotArchived)}}
+	}
+	e := es[0]
+	// the last exchange is kept so it can be replayed again
+	if len(es) > 1 {
+		rp.entries[k] = es[1:]
+	}
+	rp.mu.Unlock()
+	res := e.Response
+	r := ResponseInfo{
+		StatusCode:      res.Status,
+		Proto:           res.HTTPVersion,
+		ContentType:     res.Content.MimeType,
+		ContentLength:   res.ContentLength,
+		ContentEncoding: res.ContentEncoding,
+		Charset:         res.Charset,
+		Truncated:       res.Truncated,
+		Meta:            res.Meta,
+		Redirects:       res.Redirects,
+		Duration:        time.Duration(e.Time * float64(time.Millisecond)),
+		FirstByte:       time.Duration(e.Timings.Wait * float64(time.Millisecond)),
+	}
+	if res.Status > 0 {
+		r.Status = strings.TrimSpace(strconv.Itoa(res.Status) + " " + res.StatusText)
+	}
+	if len(res.Headers) > 0 {
+		r.Header = make(http.Header, len(res.Headers))
+		for _, h := range res.Headers {
+			r.Header.Add(h.Name, h.Value)
+		}
+	}
+	if res.BodySize > 0 {
+		r.CompressedBytes = res.BodySize
+	}
+	r.BodyBytes = res.Content.Size
+	if res.Error != "" {
+		r.Err = &replayedError{msg: res.Error, reason: res.ErrorReason}
+	}
+	body := res.Content.Text
+	if res.Content.Encoding == "base64" {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			r.Err = fmt.Errorf("replay %s: %w", k.url, err)
+			return Result{ResponseInfo: r}
+		}
+		body = string(b)
+	}
+	return Result{Body: body, ResponseInfo: r, URLs: e.URLs}
+}
+
+// replayedError is an error replayed from an archive. It wraps an error that
+// errorReason classifies the way the recorded error was.
+type replayedError struct {
+	msg    string
+	reason string // the recorded errorReason; BrokenError if there wasn't one
+}
+
+func (e *replayedError) Error() string { return e.msg }
+
+// Timeout implements net.Error.
+func (e *replayedError) Timeout() bool { return e.reason == BrokenTimeout }
+
+// Temporary implements net.Error.
+func (e *replayedError) Temporary() bool {
+	return e.reason == BrokenTimeout || e.reason == BrokenConnection
+}
+
+func (e *replayedError) Unwrap() error {
+	switch e.reason {
+	case BrokenDNS:
+		return &net.DNSError{Err: e.msg}
+	case BrokenTLS:
+		return tls.RecordHeaderError{Msg: e.msg}
+	case BrokenConnection:
+		return syscall.ECONNRESET
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package geomi
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	binary := string([]byte{0x89, 'P', 'N', 'G', 0xff, 0x00})
+	f := statusFetcher{
+		"http://golang.org/doc/": {
+			`<a href="a">a</a>`,
+			ResponseInfo{Status: "200 OK", StatusCode: 200, ContentType: "text/html", Header: http.Header{"Content-Type": {"text/html"}, "Set-Cookie": {"a=1", "b=2"}}, CompressedBytes: 10, BodyBytes: 17, Charset: "utf-8"},
+			[]string{"http://golang.org/doc/a", "http://golang.org/doc/logo.png", "http://golang.org/doc/gone"},
+		},
+		"http://golang.org/doc/a":        {"A", ResponseInfo{Status: "200 OK", StatusCode: 200, ContentType: "text/plain", BodyBytes: 1, Meta: map[string]string{"title": "A"}}, nil},
+		"http://golang.org/doc/logo.png": {binary, ResponseInfo{Status: "200 OK", StatusCode: 200, ContentType: "image/png", BodyBytes: 6}, nil},
+	}
+	var buf bytes.Buffer
+	rec := NewRecorder(AdaptFetcher(f), &buf)
+	s := crawledSpider(t, "http://golang.org/doc/", rec)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rp, err := NewReplayer(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := crawledSpider(t, "http://golang.org/doc/", rp)
+	if len(replayed.Pages) != len(s.Pages) {
+		t.Fatalf("Expected %d pages, got %d", len(s.Pages), len(replayed.Pages))
+	}
+	for k, p := range s.Pages {
+		rp, ok := replayed.Pages[k]
+		if !ok {
+			t.Errorf("%s: expected the page to be replayed", k)
+			continue
+		}
+		if !reflect.DeepEqual(rp.links, p.links) {
+			t.Errorf("%s: expected the links %v, got %v", k, p.links, rp.links)
+		}
+		body, _ := p.Body()
+		rbody, _ := rp.Body()
+		if body != rbody {
+			t.Errorf("%s: expected the body %q, got %q", k, body, rbody)
+		}
+		r, rr := s.fetchedURLs[k], replayed.fetchedURLs[k]
+		// the duration and attempts are the replay's own
+		rr.Duration, rr.Attempts, r.Duration, r.Attempts = 0, nil, 0, nil
+		if !reflect.DeepEqual(r, rr) {
+			t.Errorf("%s: expected the response %+v, got %+v", k, r, rr)
+		}
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	reset := &url.Error{Op: "Get", URL: "http://golang.org/", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}
+	f := &flakyFetcher{failures: []ResponseInfo{{Status: "503 Service Unavailable", StatusCode: 503}, {Err: reset}}}
+	name := filepath.Join(t.TempDir(), "crawl.har")
+	rec, err := CreateRecorder(AdaptFetcher(f), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		rec.FetchRequest(Request{URL: "http://golang.org/"})
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rp, err := LoadReplayer(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the exchanges are replayed in order, and the last is repeated
+	tests := []struct {
+		status string
+		err    string
+	}{
+		{"503 Service Unavailable", ""},
+		{"", reset.Error()},
+		{"200 OK", ""},
+		{"200 OK", ""},
+	}
+	for i, test := range tests {
+		res := rp.FetchRequest(Request{URL: "http://golang.org/"})
+		if res.Status != test.status {
+			t.Errorf("%d: expected the status %q, got %q", i, test.status, res.Status)
+		}
+		if (res.Err == nil && test.err != "") || (res.Err != nil && res.Err.Error() != test.err) {
+			t.Errorf("%d: expected the error %q, got %v", i, test.err, res.Err)
+		}
+		if res.Err != nil && errorReason(res.Err) != BrokenConnection {
+			t.Errorf("%d: expected the error to be a %s, got %s", i, BrokenConnection, errorReason(res.Err))
+		}
+	}
+	if res := rp.FetchRequest(Request{URL: "http://golang.org/doc/"}); !errors.Is(res.Err, ErrNotArchived) {
+		t.Errorf("Expected a url that wasn't recorded to be ErrNotArchived, got %v", res.Err)
+	}
+}
+
+func TestReplayedErrors(t *testing.T) {
+	for _, err := range []error{
+		&url.Error{Op: "Get", URL: "http://golang.org/", Err: &net.DNSError{Err: "no such host", Name: "golang.org"}},
+		&url.Error{Op: "Get", URL: "https://golang.org/", Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}},
+		&url.Error{Op: "Get", URL: "http://golang.org/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}},
+		&url.Error{Op: "Get", URL: "http://golang.org/", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}},
+		errors.New("nothing in body"),
+	} {
+		var buf bytes.Buffer
+		rec := NewRecorder(AdaptFetcher(statusFetcher{"http://golang.org/": {"", ResponseInfo{Err: err}, nil}}), &buf)
+		rec.FetchRequest(Request{URL: "http://golang.org/"})
+		if err := rec.Close(); err != nil {
+			t.Fatal(err)
+		}
+		rp, rerr := NewReplayer(&buf)
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		res := rp.FetchRequest(Request{URL: "http://golang.org/"})
+		if res.Err == nil || res.Err.Error() != err.Error() {
+			t.Errorf("Expected the error %q, got %v", err, res.Err)
+			continue
+		}
+		if reason := errorReason(err); errorReason(res.Err) != reason {
+			t.Errorf("%q: expected the replayed error to be a %s, got %s", err, reason, errorReason(res.Err))
+		}
+	}
+}
+
+func TestReplayRetries(t *testing.T) {
+	reset := &url.Error{Op: "Get", URL: "http://golang.org/", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}
+	retry := func(c *Config) {
+		c.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryErrors: true, RetryStatusCodes: DefaultRetryStatusCodes}
+	}
+	var buf bytes.Buffer
+	f := &flakyFetcher{failures: []ResponseInfo{{Status: "503 Service Unavailable", StatusCode: 503}, {Err: reset}}}
+	rec := NewRecorder(AdaptFetcher(f), &buf)
+	s := crawledSpider(t, "http://golang.org/", rec, retry)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rp, err := NewReplayer(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := crawledSpider(t, "http://golang.org/", rp, retry)
+	r, rr := s.fetchedURLs["http://golang.org/"], replayed.fetchedURLs["http://golang.org/"]
+	if rr.StatusCode != 200 || rr.Err != nil {
+		t.Errorf("Expected the replayed crawl to get a 200 after retrying, got %d, %v", rr.StatusCode, rr.Err)
+	}
+	if len(rr.Attempts) != len(r.Attempts) || len(rr.Attempts) != 3 {
+		t.Fatalf("Expected 3 attempts, as recorded, got %d", len(rr.Attempts))
+	}
+	for i, a := range rr.Attempts {
+		if a.StatusCode != r.Attempts[i].StatusCode || (a.Err == nil) != (r.Attempts[i].Err == nil) {
+			t.Errorf("attempt %d: expected %d, %v; got %d, %v", i, r.Attempts[i].StatusCode, r.Attempts[i].Err, a.StatusCode, a.Err)
+		}
+	}
+}
+
+func TestReplayRobotsAndExternal(t *testing.T) {
+	ext := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			http.NotFound(w, r)
+		}
+	}))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private/\n")
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<a href="%s/ok">ok</a><a href="%s/gone">gone</a>`, ext.URL, ext.URL)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	crawl := func(f RequestFetcher) *Spider {
+		return crawledSpider(t, ts.URL+"/", f, func(c *Config) {
+			c.RespectRobots = true
+			c.CheckExternalLinks = true
+			c.ExternalRetry = RetryPolicy{}
+		})
+	}
+	var buf bytes.Buffer
+	rec := NewRecorder(Site{Config: NewConfig()}, &buf)
+	s := crawl(rec)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// nothing is requested over the network when the crawl is replayed
+	ts.Close()
+	ext.Close()
+	var har harFile
+	if err := json.Unmarshal(buf.Bytes(), &har); err != nil {
+		t.Fatal(err)
+	}
+	rp, err := NewReplayer(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := crawl(rp)
+	if replayed.robots == nil || replayed.robotsAllowed(&url.URL{Path: "/private/"}) || !replayed.robotsAllowed(&url.URL{Path: "/"}) {
+		t.Error("Expected the robots.txt to be replayed")
+	}
+	for _, u := range []string{ext.URL + "/ok", ext.URL + "/gone"} {
+		r, rr := s.externalLinks[u], replayed.externalLinks[u]
+		if rr.Err != nil || rr.StatusCode != r.StatusCode {
+			t.Errorf("%s: expected the check to be replayed with %d, got %d, %v", u, r.StatusCode, rr.StatusCode, rr.Err)
+		}
+	}
+	// a replay doesn't fall back to the network for what wasn't recorded
+	var pages []harEntry
+	for _, e := range har.Log.Entries {
+		if e.Kind == "" {
+			pages = append(pages, e)
+		}
+	}
+	har.Log.Entries = pages
+	b, err := json.Marshal(har)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, err = NewReplayer(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed = crawl(rp)
+	if r := replayed.externalLinks[ext.URL+"/ok"]; !errors.Is(r.Err, ErrNotArchived) {
+		t.Errorf("Expected a check that wasn't recorded to be ErrNotArchived, got %v", r.Err)
+	}
+}
+
+func TestRecorderHAR(t *testing.T) {
+	f := RequestFetcherFunc(func(req Request) Result {
+		// the wait is part of the exchange's time
+		time.Sleep(2 * time.Millisecond)
+		h := http.Header{"Location": {"/doc/"}, "Set-Cookie": {"id=1; Path=/; HttpOnly"}}
+		r := ResponseInfo{Status: "301 Moved Permanently", StatusCode: 301, Proto: "HTTP/1.1", Header: h, FirstByte: time.Millisecond}
+		return Result{ResponseInfo: r}
+	})
+	var buf bytes.Buffer
+	rec := NewRecorder(f, &buf)
+	rec.FetchRequest(Request{URL: "http://golang.org/search?q=go+doc&page=2", Header: http.Header{"Cookie": {"a=1; b=2"}}})
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var har struct {
+		Log struct {
+			Version string
+			Creator harCreator
+			Entries []map[string]json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &har); err != nil {
+		t.Fatalf("Expected the archive to be JSON, got %q: %s", err, buf.String())
+	}
+	if har.Log.Version != ArchiveVersion || har.Log.Creator.Name != "geomi" || har.Log.Creator.Version == ArchiveVersion {
+		t.Errorf("Expected HAR %s created by geomi, with geomi's version, got %+v", ArchiveVersion, har.Log)
+	}
+	if len(har.Log.Entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(har.Log.Entries))
+	}
+	// the fields HAR 1.2 requires
+	e := har.Log.Entries[0]
+	for _, k := range []string{"startedDateTime", "time", "request", "response", "cache", "timings"} {
+		if _, ok := e[k]; !ok {
+			t.Errorf("Expected the entry to have %q", k)
+		}
+	}
+	var req, resp map[string]json.RawMessage
+	json.Unmarshal(e["request"], &req)
+	json.Unmarshal(e["response"], &resp)
+	for _, k := range []string{"method", "url", "httpVersion", "cookies", "headers", "queryString", "headersSize", "bodySize"} {
+		if _, ok := req[k]; !ok {
+			t.Errorf("Expected the request to have %q", k)
+		}
+	}
+	for _, k := range []string{"status", "statusText", "httpVersion", "cookies", "headers", "content", "redirectURL", "headersSize", "bodySize"} {
+		if _, ok := resp[k]; !ok {
+			t.Errorf("Expected the response to have %q", k)
+		}
+	}
+	var entry harEntry
+	if err := json.Unmarshal(har.Log.Entries[0]["request"], &entry.Request); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(har.Log.Entries[0]["response"], &entry.Response)
+	json.Unmarshal(har.Log.Entries[0]["timings"], &entry.Timings)
+	if q := []harHeader{{"q", "go doc"}, {"page", "2"}}; !reflect.DeepEqual(entry.Request.QueryString, q) {
+		t.Errorf("Expected the query string %v, got %v", q, entry.Request.QueryString)
+	}
+	if c := []harCookie{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}; !reflect.DeepEqual(entry.Request.Cookies, c) {
+		t.Errorf("Expected the request cookies %v, got %v", c, entry.Request.Cookies)
+	}
+	if c := []harCookie{{Name: "id", Value: "1", Path: "/", HTTPOnly: true}}; !reflect.DeepEqual(entry.Response.Cookies, c) {
+		t.Errorf("Expected the response cookies %v, got %v", c, entry.Response.Cookies)
+	}
+	if entry.Response.RedirectURL != "/doc/" || entry.Response.HTTPVersion != "HTTP/1.1" {
+		t.Errorf("Expected the redirect url and http version, got %q and %q", entry.Response.RedirectURL, entry.Response.HTTPVersion)
+	}
+	if entry.Timings.Wait != 1 {
+		t.Errorf("Expected a wait of 1ms, got %v", entry.Timings.Wait)
+	}
+}
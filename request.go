@@ -0,0 +1,81 @@
+package geomi
+
+import (
+	"net/http"
+)
+
+// Request is a url that the crawl wants fetched, along with what the crawl knows
+// about it.
+type Request struct {
+	URL      string
+	Depth    int         // the url's distance from the start url; 0 for the start url
+	Referrer string      // the url of the page the url was found on; empty for the start url
+	Header   http.Header // the headers to send, e.g. the User-Agent and Referer
+	wire     bool        // whether the exchanges, as they were sent and received, are wanted, for a WARC
+}
+
+// Result is the outcome of fetching a Request: the body to be kept, which may be
+// empty, the response's information, and the links found in the body.
+type Result struct {
+	Body string
+	ResponseInfo
+	URLs []string
+	html *htmlPage // what a Site found in an html body, so the crawl doesn't scan it again
+	// the requests that were sent, and the responses, as they were on the wire,
+	// for a WARC: the redirects that were followed, then the final response; nil
+	// if the fetcher didn't keep them
+	exchanges []*wireExchange
+}
+
+// RequestFetcher fetches the urls of a crawl. Unlike a Fetcher, it is given the
+// whole Request, so it can make use of the depth, the referrer, and the headers,
+// e.g. to cache, record, or render pages.
+type RequestFetcher interface {
+	FetchRequest(req Request) Result
+}
+
+// RequestFetcherFunc is a function that implements RequestFetcher.
+type RequestFetcherFunc func(req Request) Result
+
+// FetchRequest calls f.
+func (f RequestFetcherFunc) FetchRequest(req Request) Result {
+	return f(req)
+}
+
+// AdaptFetcher returns a RequestFetcher that fetches the url of each Request
+// with the Fetcher; everything else in the Request is ignored.
+func AdaptFetcher(f Fetcher) RequestFetcher {
+	return RequestFetcherFunc(func(req Request) Result {
+		body, r, urls := f.Fetch(req.URL)
+		return Result{Body: body, ResponseInfo: r, URLs: urls}
+	})
+}
+
+// FetchRequest implements RequestFetcher. The Request's headers are sent with
+// the request, except for Accept-Encoding, which the Site sets so it can decode
+// the body. See Fetch.
+func (s Site) FetchRequest(req Request) Result {
+	var wire *wireTransport
+	if req.wire {
+		wire = &wireTransport{}
+	}
+	body, r, urls, hp := s.fetch(req, wire)
+	res := Result{Body: body, ResponseInfo: r, URLs: urls, html: hp}
+	if wire != nil {
+		res.exchanges = wire.exchanges
+	}
+	return res
+}
+
+// request returns the Request for the page: its depth, its referrer, and the
+// headers from the config.
+func (s *Spider) request(p Page) Request {
+	req := Request{URL: p.URL.String(), Depth: p.distance, Referrer: p.referrer, Header: make(http.Header)}
+	if s.Config.UserAgent != "" {
+		req.Header.Set("User-Agent", s.Config.UserAgent)
+	}
+	if p.referrer != "" {
+		req.Header.Set("Referer", p.referrer)
+	}
+	return req
+}
@@ -0,0 +1,67 @@
+package geomi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestCrawlWith(t *testing.T) {
+	var mu sync.Mutex
+	var reqs []Request
+	f := RequestFetcherFunc(func(req Request) Result {
+		mu.Lock()
+		reqs = append(reqs, req)
+		mu.Unlock()
+		return AdaptFetcher(linkTester).FetchRequest(req)
+	})
+	s, err := NewSpider("http://golang.org/cmd/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Config.SetFetchInterval(0)
+	s.Config.CheckExternalLinks = false
+	s.Config.RespectRobots = false
+	s.Config.UserAgent = "geomi-test"
+	if _, err := s.CrawlWith(f, -1); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Pages) != 2 {
+		t.Errorf("Expected 2 pages, got %d", len(s.Pages))
+	}
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].URL < reqs[j].URL })
+	expected := []Request{
+		{URL: "http://golang.org/cmd/", Header: http.Header{"User-Agent": {"geomi-test"}}},
+		{URL: "http://golang.org/cmd/gofmt/", Depth: 1, Referrer: "http://golang.org/cmd/", Header: http.Header{"User-Agent": {"geomi-test"}, "Referer": {"http://golang.org/cmd/"}}},
+	}
+	if !reflect.DeepEqual(reqs, expected) {
+		t.Errorf("Expected %v, got %v", expected, reqs)
+	}
+}
+
+func TestSiteFetchRequest(t *testing.T) {
+	var h http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h = r.Header
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="/a">a</a>`))
+	}))
+	defer ts.Close()
+	s := Site{Config: NewConfig()}
+	res := s.FetchRequest(Request{URL: ts.URL, Header: http.Header{"User-Agent": {"geomi-test"}, "Referer": {"http://golang.org/"}, "Accept-Encoding": {"zstd"}}})
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if res.StatusCode != 200 || !reflect.DeepEqual(res.URLs, []string{ts.URL + "/a"}) {
+		t.Errorf("Expected a 200 with a link to /a, got %d %v", res.StatusCode, res.URLs)
+	}
+	if h.Get("User-Agent") != "geomi-test" || h.Get("Referer") != "http://golang.org/" {
+		t.Errorf("Expected the request's headers to be sent, got %v", h)
+	}
+	if h.Get("Accept-Encoding") != AcceptEncoding {
+		t.Errorf("Expected the Accept-Encoding to be %q, got %q", AcceptEncoding, h.Get("Accept-Encoding"))
+	}
+}
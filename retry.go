@@ -0,0 +1,158 @@
+package geomi
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Each retry waits twice as
+// long as the one before it, starting with Backoff.
+type RetryPolicy struct {
+	MaxAttempts       int           // The max number of attempts, including the first; < 1 is treated as 1.
+	Backoff           time.Duration // The wait before the first retry.
+	MaxBackoff        time.Duration // The max wait between attempts; 0 means no limit.
+	Jitter            time.Duration // The max amount of jitter to add to each wait, the actual jitter is random.
+	RetryErrors       bool          // Whether timeouts and connection errors are retried.
+	RetryStatusCodes  []int         // The response status codes that are retried.
+	RespectRetryAfter bool          // Whether a response's Retry-After header is used as the wait.
+}
+
+// Attempt is the result of a single try at getting a url.
+type Attempt struct {
+	Status     string
+	StatusCode int
+	Err        error
+	Duration   time.Duration // how long the request took to return
+	FirstByte  time.Duration // how long until the response's headers were received; 0 if unknown
+	Wait       time.Duration // how long was waited before the next attempt
+}
+
+// DefaultRetryStatusCodes are the status codes that are retried by default.
+var DefaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// NewRetryPolicy returns a RetryPolicy that makes up to attempts attempts,
+// starting with a backoff of 1 second, retrying errors and the default retry
+// status codes.
+func NewRetryPolicy(attempts int) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:       attempts,
+		Backoff:           time.Second,
+		MaxBackoff:        time.Minute,
+		Jitter:            time.Second,
+		RetryErrors:       true,
+		RetryStatusCodes:  DefaultRetryStatusCodes,
+		RespectRetryAfter: true,
+	}
+}
+
+// attempts returns the max number of attempts.
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// retryable returns whether the response should be retried. Of the errors, only
+// timeouts and connection errors are retried; retrying dns and tls errors won't
+// change anything.
+func (p RetryPolicy) retryable(r ResponseInfo) bool {
+	if r.Err != nil {
+		if !p.RetryErrors {
+			return false
+		}
+		reason := errorReason(r.Err)
+		return reason == BrokenTimeout || reason == BrokenConnection
+	}
+	for _, code := range p.RetryStatusCodes {
+		if r.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait before the next attempt, after attempt
+// attempts have been made.
+func (p RetryPolicy) delay(attempt int, r ResponseInfo) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff == 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.RespectRetryAfter {
+		if d, ok := retryAfter(r.Header, time.Now()); ok {
+			wait = d
+		}
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait += time.Duration(rand.Int63n(p.Jitter.Nanoseconds()))
+	}
+	return wait
+}
+
+// attempt returns the Attempt for the response.
+func attempt(r ResponseInfo) Attempt {
+	return Attempt{Status: r.Status, StatusCode: r.StatusCode, Err: r.Err, Duration: r.Duration, FirstByte: r.FirstByte}
+}
+
+// fetch gets the request's url using the fetcher, retrying according to the
+// spider's retry policy. The returned ResponseInfo is that of the last attempt,
+// with every attempt recorded in its Attempts.
+func (s *Spider) fetch(fetcher RequestFetcher, req Request) (res Result) {
+	policy := s.Config.Retry
+	var attempts []Attempt
+	for i := 1; ; i++ {
+		// the first request was taken by the caller
+		if i > 1 {
+			if s.stopReason = s.takeRequest(); s.stopReason != "" {
+				res.Attempts = attempts
+				return res
+			}
+		}
+		start := time.Now()
+		res = fetcher.FetchRequest(req)
+		res.Duration = time.Since(start)
+		attempts = append(attempts, attempt(res.ResponseInfo))
+		if i >= policy.attempts() || !policy.retryable(res.ResponseInfo) {
+			res.Attempts = attempts
+			return res
+		}
+		wait := policy.delay(i, res.ResponseInfo)
+		attempts[len(attempts)-1].Wait = wait
+		time.Sleep(wait)
+	}
+}
+
+// retryAfter returns the wait specified by the Retry-After header, which is
+// either a number of seconds or an HTTP date.
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
@@ -0,0 +1,114 @@
+package geomi
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v        string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Mon, 01 Jun 2015 12:00:30 GMT", 30 * time.Second, true},
+		{"Mon, 01 Jun 2015 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, test := range tests {
+		h := http.Header{}
+		if test.v != "" {
+			h.Set("Retry-After", test.v)
+		}
+		d, ok := retryAfter(h, now)
+		if d != test.expected || ok != test.ok {
+			t.Errorf("%q: expected %v, %t; got %v, %t", test.v, test.expected, test.ok, d, ok)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second, RespectRetryAfter: true}
+	tests := []struct {
+		attempt  int
+		header   string
+		expected time.Duration
+	}{
+		{1, "", time.Second},
+		{2, "", 2 * time.Second},
+		{3, "", 4 * time.Second},
+		{4, "", 5 * time.Second},
+		{1, "3", 3 * time.Second},
+		{1, "60", 5 * time.Second},
+	}
+	for _, test := range tests {
+		r := ResponseInfo{Header: http.Header{}}
+		if test.header != "" {
+			r.Header.Set("Retry-After", test.header)
+		}
+		if d := p.delay(test.attempt, r); d != test.expected {
+			t.Errorf("attempt %d, Retry-After %q: expected %v, got %v", test.attempt, test.header, test.expected, d)
+		}
+	}
+}
+
+// flakyFetcher fails with the responses in order, then succeeds.
+type flakyFetcher struct {
+	failures []ResponseInfo
+	calls    int
+}
+
+func (f *flakyFetcher) Fetch(url string) (string, ResponseInfo, []string) {
+	f.calls++
+	if f.calls <= len(f.failures) {
+		return "", f.failures[f.calls-1], nil
+	}
+	return "ok", ResponseInfo{Status: "200 OK", StatusCode: 200}, nil
+}
+
+func TestSpiderFetchRetry(t *testing.T) {
+	reset := &url.Error{Op: "Get", URL: "http://golang.org/", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}
+	dns := &url.Error{Op: "Get", URL: "http://golang.org/", Err: &net.DNSError{Err: "no such host", Name: "golang.org"}}
+	tests := []struct {
+		failures    []ResponseInfo
+		retryErrors bool
+		expected    int // the final status code
+		attempts    int
+	}{
+		{nil, true, 200, 1},
+		{[]ResponseInfo{{StatusCode: 503}, {StatusCode: 429}}, true, 200, 3},
+		{[]ResponseInfo{{StatusCode: 503}, {StatusCode: 503}, {StatusCode: 503}}, true, 503, 3},
+		{[]ResponseInfo{{StatusCode: 404}}, true, 404, 1},
+		{[]ResponseInfo{{Err: reset}}, true, 200, 2},
+		{[]ResponseInfo{{Err: reset}}, false, 0, 1},
+		{[]ResponseInfo{{Err: dns}}, true, 0, 1},
+		{[]ResponseInfo{{Err: errors.New("nothing in body")}}, true, 0, 1},
+	}
+	for i, test := range tests {
+		s, _ := NewSpider("http://golang.org/")
+		s.Config.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryErrors: test.retryErrors, RetryStatusCodes: DefaultRetryStatusCodes}
+		f := &flakyFetcher{failures: test.failures}
+		r := s.fetch(AdaptFetcher(f), Request{URL: "http://golang.org/"})
+		if r.StatusCode != test.expected {
+			t.Errorf("%d: expected status %d, got %d", i, test.expected, r.StatusCode)
+		}
+		if len(r.Attempts) != test.attempts {
+			t.Errorf("%d: expected %d attempts, got %d", i, test.attempts, len(r.Attempts))
+			continue
+		}
+		for j, a := range r.Attempts[:len(r.Attempts)-1] {
+			if a.Wait < time.Millisecond {
+				t.Errorf("%d: expected attempt %d to have waited, it didn't", i, j)
+			}
+		}
+	}
+}
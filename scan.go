@@ -0,0 +1,355 @@
+package geomi
+
+import (
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// htmlPage is what scanHTML finds in an html page.
+type htmlPage struct {
+	urls       []string // the links to crawl: a elements, stylesheets, and feeds
+	links      []Link
+	anchors    []string    // sorted
+	alternates []Alternate // the hreflang alternates, found even if the content isn't extracted
+	content    Content
+	hasContent bool  // whether the content was extracted
+	err        error // an href, of a url to crawl, that couldn't be parsed, or a tokenizer error
+}
+
+// voidElements are the html elements that don't have an end tag.
+var voidElements = map[string]bool{
+	"area":   true,
+	"base":   true,
+	"br":     true,
+	"col":    true,
+	"embed":  true,
+	"hr":     true,
+	"img":    true,
+	"input":  true,
+	"link":   true,
+	"meta":   true,
+	"param":  true,
+	"source": true,
+	"track":  true,
+	"wbr":    true,
+}
+
+// unwalkedElements are the elements whose contents aren't looked at for links,
+// anchors, and link text.
+var unwalkedElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"template": true,
+}
+
+// scanElement is an element that is open while the html is scanned.
+type scanElement struct {
+	tag     string
+	context string // the region of the page the element is, e.g. ContextNav, if it is one
+}
+
+// htmlScanner finds everything geomi wants from an html page in a single pass
+// over its tokens.
+type htmlScanner struct {
+	base     *url.URL
+	hasBase  bool // whether the base has been set by a base element; only the first counts
+	page     *htmlPage
+	content  *contentScanner // nil if the content isn't extracted
+	stack    []scanElement   // the open elements
+	unwalked int             // the number of open unwalkedElements
+	anchors  map[string]bool
+	link     int // the index of the a whose text is being read, -1 if there isn't one
+	text     strings.Builder
+	alt      strings.Builder
+}
+
+// scanHTML tokenizes the html read from r once, finding the links to crawl, the
+// Links, the anchors, and, if content is true, the Content; everything is read,
+// so r can be teed. The html isn't parsed into a tree, the open elements are
+// tracked so a link's context can be found. Links are resolved against base, or
+// against the page's base element's href, if it has one.
+func scanHTML(base *url.URL, r io.Reader, content bool) *htmlPage {
+	s := &htmlScanner{base: base, page: &htmlPage{hasContent: content}, anchors: make(map[string]bool), link: -1}
+	if content {
+		s.content = &contentScanner{base: base}
+	}
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				s.setErr(err)
+				// the rest still has to be read
+				io.Copy(io.Discard, r)
+			}
+			return s.done()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := string(name)
+			var attrs []html.Attribute
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				attrs = append(attrs, html.Attribute{Key: string(key), Val: string(val)})
+			}
+			s.start(tt, tag, attrs)
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			s.end(string(name))
+		case html.TextToken:
+			s.textToken(string(z.Text()))
+		}
+	}
+}
+
+// start handles a start, or self closing, tag.
+func (s *htmlScanner) start(tt html.TokenType, tag string, attrs []html.Attribute) {
+	if tag == "base" && !s.hasBase && s.unwalked == 0 {
+		s.setBase(attrs)
+	}
+	if s.content != nil {
+		s.content.start(tt, tag, firstAttrs(attrs))
+	}
+	s.addURL(tag, attrs)
+	if tag == "link" {
+		s.addAlternate(attrs)
+	}
+	if s.unwalked == 0 {
+		if id, _ := firstAttr(attrs, "id"); id != "" {
+			s.anchors[id] = true
+		}
+		switch tag {
+		case "a":
+			if name, _ := firstAttr(attrs, "name"); name != "" {
+				s.anchors[name] = true
+			}
+			// an a closes the one before it
+			s.endLink()
+			if s.addLink(attrs, "") && tt == html.StartTagToken {
+				s.link = len(s.page.links) - 1
+			}
+		case "area":
+			alt, _ := firstAttr(attrs, "alt")
+			s.addLink(attrs, collapseSpace(alt))
+		case "img":
+			if s.link >= 0 {
+				alt, _ := firstAttr(attrs, "alt")
+				s.alt.WriteString(alt)
+				s.alt.WriteByte(' ')
+			}
+		}
+	}
+	if tt == html.SelfClosingTagToken || voidElements[tag] {
+		return
+	}
+	e := scanElement{tag: tag}
+	role, _ := firstAttr(attrs, "role")
+	if c, ok := landmarkRoles[strings.ToLower(strings.TrimSpace(role))]; ok {
+		e.context = c
+	} else {
+		e.context = landmarkElements[tag]
+	}
+	s.stack = append(s.stack, e)
+	if unwalkedElements[tag] {
+		s.unwalked++
+	}
+}
+
+// end handles an end tag: the element, and any elements opened in it that are
+// still open, are closed. An end tag without an open element is ignored.
+func (s *htmlScanner) end(tag string) {
+	if s.content != nil {
+		s.content.end(tag)
+	}
+	for i := len(s.stack) - 1; i >= 0; i-- {
+		if s.stack[i].tag != tag {
+			continue
+		}
+		for _, e := range s.stack[i:] {
+			if unwalkedElements[e.tag] {
+				s.unwalked--
+			}
+			if e.tag == "a" {
+				s.endLink()
+			}
+		}
+		s.stack = s.stack[:i]
+		return
+	}
+}
+
+// textToken handles text.
+func (s *htmlScanner) textToken(text string) {
+	if s.content != nil {
+		s.content.text(text)
+	}
+	if s.link >= 0 && s.unwalked == 0 {
+		s.text.WriteString(text)
+		s.text.WriteByte(' ')
+	}
+}
+
+// setBase sets the url that links are resolved against to the base element's
+// href, if it has one that can be parsed.
+func (s *htmlScanner) setBase(attrs []html.Attribute) {
+	href, ok := firstAttr(attrs, "href")
+	if !ok {
+		return
+	}
+	s.hasBase = true
+	u, err := s.base.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return
+	}
+	s.base = u
+	if s.content != nil {
+		s.content.base = u
+	}
+}
+
+// addURL adds the url of an a, or of a link to a stylesheet or feed, to the
+// urls to crawl. Links to just a fragment, or in an unwalked element, aren't
+// crawled; an href that can't be parsed is an error.
+func (s *htmlScanner) addURL(tag string, attrs []html.Attribute) {
+	if s.unwalked > 0 || (tag != "a" && tag != "link") {
+		return
+	}
+	href, ok := firstAttr(attrs, "href")
+	href = strings.TrimSpace(href)
+	if !ok || href == "" || strings.HasPrefix(href, "#") {
+		return
+	}
+	if tag == "link" {
+		rel, _ := firstAttr(attrs, "rel")
+		typ, _ := firstAttr(attrs, "type")
+		if !linkedResource(rel, strings.TrimSpace(typ)) {
+			return
+		}
+	}
+	u, err := s.base.Parse(href)
+	if err != nil {
+		s.setErr(err)
+		return
+	}
+	s.page.urls = append(s.page.urls, u.String())
+}
+
+// addAlternate adds the link element's hreflang alternate, if it is one.
+func (s *htmlScanner) addAlternate(attrs []html.Attribute) {
+	rel, _ := firstAttr(attrs, "rel")
+	lang, _ := firstAttr(attrs, "hreflang")
+	if lang = strings.TrimSpace(lang); lang == "" || !hasToken(rel, "alternate") {
+		return
+	}
+	href, _ := firstAttr(attrs, "href")
+	s.page.alternates = append(s.page.alternates, Alternate{Hreflang: lang, URL: resolveRef(s.base, href)})
+}
+
+// addLink adds the a or area to the Links, if it has an href, returning whether
+// it was added. Links to just # are skipped; links to a fragment on the page are
+// kept so the fragment can be validated.
+func (s *htmlScanner) addLink(attrs []html.Attribute, text string) bool {
+	href, ok := firstAttr(attrs, "href")
+	href = strings.TrimSpace(href)
+	if !ok || href == "" || href == "#" {
+		return false
+	}
+	u, err := s.base.Parse(href)
+	if err != nil {
+		return false
+	}
+	title, _ := firstAttr(attrs, "title")
+	target, _ := firstAttr(attrs, "target")
+	rel, _ := firstAttr(attrs, "rel")
+	l := Link{
+		URL:      u.String(),
+		Text:     text,
+		Title:    strings.TrimSpace(title),
+		Target:   target,
+		Context:  s.context(),
+		Position: len(s.page.links) + 1,
+	}
+	if rel := strings.Fields(strings.ToLower(rel)); len(rel) > 0 {
+		l.Rel = rel
+	}
+	s.page.links = append(s.page.links, l)
+	return true
+}
+
+// endLink sets the text of the a being read, if there is one: its text or, if it
+// has none, the alt text of its images.
+func (s *htmlScanner) endLink() {
+	if s.link < 0 {
+		return
+	}
+	text := collapseSpace(s.text.String())
+	if text == "" {
+		text = collapseSpace(s.alt.String())
+	}
+	s.page.links[s.link].Text = text
+	s.link = -1
+	s.text.Reset()
+	s.alt.Reset()
+}
+
+// context returns the region of the page the current element is in: its nearest
+// open ancestor that is a landmark element, or has a landmark role.
+func (s *htmlScanner) context() string {
+	for i := len(s.stack) - 1; i >= 0; i-- {
+		if c := s.stack[i].context; c != "" {
+			return c
+		}
+	}
+	return ""
+}
+
+// setErr records the first error.
+func (s *htmlScanner) setErr(err error) {
+	if s.page.err == nil {
+		s.page.err = err
+	}
+}
+
+// done finishes the scan.
+func (s *htmlScanner) done() *htmlPage {
+	s.endLink()
+	if s.content != nil {
+		s.page.content = s.content.done()
+		s.page.content.Alternates = s.page.alternates
+	}
+	for a := range s.anchors {
+		s.page.anchors = append(s.page.anchors, a)
+	}
+	sort.Strings(s.page.anchors)
+	if s.page.err != nil {
+		s.page.urls = nil
+	}
+	return s.page
+}
+
+// firstAttr returns the value of the first attribute with the key, and whether
+// there is one.
+func firstAttr(attrs []html.Attribute, key string) (string, bool) {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// firstAttrs returns the attributes by key; the first of an attribute wins.
+func firstAttrs(attrs []html.Attribute) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for _, a := range attrs {
+		if _, ok := m[a.Key]; !ok {
+			m[a.Key] = a.Val
+		}
+	}
+	return m
+}
@@ -0,0 +1,157 @@
+package geomi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanHTML(t *testing.T) {
+	base, _ := url.Parse("http://golang.org/doc/")
+	tests := []struct {
+		name    string
+		html    string
+		urls    []string
+		links   []Link
+		anchors []string
+	}{
+		{
+			"unwalked",
+			`<a href="a" id="a">A<script>var s = "<a href='x'>";</script></a><template><a href="t" id="t">T</a></template><style>#s{}</style>`,
+			[]string{"http://golang.org/doc/a"},
+			[]Link{{URL: "http://golang.org/doc/a", Text: "A", Position: 1}},
+			[]string{"a"},
+		},
+		{
+			"unclosed",
+			`<nav><a href="a">A<a href="b">B</nav><p><a href="c">C</p> after`,
+			[]string{"http://golang.org/doc/a", "http://golang.org/doc/b", "http://golang.org/doc/c"},
+			[]Link{
+				{URL: "http://golang.org/doc/a", Text: "A", Context: ContextNav, Position: 1},
+				{URL: "http://golang.org/doc/b", Text: "B", Context: ContextNav, Position: 2},
+				{URL: "http://golang.org/doc/c", Text: "C", Position: 3},
+			},
+			nil,
+		},
+		{
+			"stray end tags",
+			`</main><main></div><a href="/">Home</a></main>`,
+			[]string{"http://golang.org/"},
+			[]Link{{URL: "http://golang.org/", Text: "Home", Context: ContextMain, Position: 1}},
+			nil,
+		},
+		{
+			"padded and duplicated hrefs",
+			`<link rel="stylesheet" href=" s.css " href="x.css"><a href="  a
+" href="b">A</a><a href=" ">Empty</a>`,
+			[]string{"http://golang.org/doc/s.css", "http://golang.org/doc/a"},
+			[]Link{{URL: "http://golang.org/doc/a", Text: "A", Position: 1}},
+			nil,
+		},
+		{
+			"base",
+			`<head><template><base href="/t/"></template><link rel="stylesheet" href="a.css"><base href="/pkg/"><base href="/cmd/"></head><a href="fmt/">fmt</a>`,
+			[]string{"http://golang.org/doc/a.css", "http://golang.org/pkg/fmt/"},
+			[]Link{{URL: "http://golang.org/pkg/fmt/", Text: "fmt", Position: 1}},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		p := scanHTML(base, strings.NewReader(test.html), false)
+		if p.err != nil {
+			t.Errorf("%s: expected no error, got %q", test.name, p.err)
+		}
+		if !reflect.DeepEqual(p.urls, test.urls) {
+			t.Errorf("%s: expected urls %v, got %v", test.name, test.urls, p.urls)
+		}
+		if !reflect.DeepEqual(p.links, test.links) {
+			t.Errorf("%s: expected links %+v, got %+v", test.name, test.links, p.links)
+		}
+		if !reflect.DeepEqual(p.anchors, test.anchors) {
+			t.Errorf("%s: expected anchors %v, got %v", test.name, test.anchors, p.anchors)
+		}
+	}
+}
+
+func TestSiteScansHTML(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><title>Docs</title><h2 id="install">Install</h2><nav><a href="/">Home</a></nav></html>`)
+	}))
+	defer ts.Close()
+	res := Site{Config: NewConfig()}.FetchRequest(Request{URL: ts.URL + "/doc/"})
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if res.html == nil {
+		t.Fatal("Expected the Site to return what it found in the html")
+	}
+	if res.html.content.Title != "Docs" || !reflect.DeepEqual(res.html.anchors, []string{"install"}) {
+		t.Errorf("Expected the title and anchors to be found, got %+v", res.html)
+	}
+	links := []Link{{URL: ts.URL + "/", Text: "Home", Context: ContextNav, Position: 1}}
+	if !reflect.DeepEqual(res.html.links, links) {
+		t.Errorf("Expected links %+v, got %+v", links, res.html.links)
+	}
+}
+
+func TestCrawlSniffsHTML(t *testing.T) {
+	// a fetcher that doesn't set the content type
+	f := RequestFetcherFunc(func(req Request) Result {
+		if req.URL != "http://golang.org/" {
+			return Result{ResponseInfo: ResponseInfo{StatusCode: 404}}
+		}
+		body := `<!DOCTYPE html><html><title>Go</title><a href="/doc/#install">Install</a></html>`
+		return Result{Body: body, ResponseInfo: ResponseInfo{StatusCode: 200}, URLs: []string{"http://golang.org/doc/#install"}}
+	})
+	s := crawledSpider(t, "http://golang.org/", f)
+	p := s.Pages["http://golang.org/"]
+	links := []Link{{URL: "http://golang.org/doc/#install", Text: "Install", Position: 1}}
+	if !reflect.DeepEqual(p.Links, links) {
+		t.Errorf("Expected links %+v, got %+v", links, p.Links)
+	}
+	if p.Title != "Go" {
+		t.Errorf("Expected the title to be extracted, got %q", p.Title)
+	}
+}
+
+func TestRedirectBase(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/docs" {
+			http.Redirect(w, r, "/docs/", http.StatusMovedPermanently)
+			return
+		}
+		fmt.Fprint(w, `<html><link rel="alternate" hreflang="ko" href="ko"><a href="intro">Intro</a></html>`)
+	}))
+	defer ts.Close()
+	c := NewConfig()
+	c.ExtractContent = true
+	res := Site{Config: c}.FetchRequest(Request{URL: ts.URL + "/docs"})
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	// links are relative to the url that was redirected to
+	if urls := []string{ts.URL + "/docs/intro"}; !reflect.DeepEqual(res.URLs, urls) {
+		t.Errorf("Expected urls %v, got %v", urls, res.URLs)
+	}
+	if alts := []Alternate{{"ko", ts.URL + "/docs/ko"}}; !reflect.DeepEqual(res.html.content.Alternates, alts) {
+		t.Errorf("Expected alternates %v, got %v", alts, res.html.content.Alternates)
+	}
+	// the same goes for fetchers that don't scan the html
+	f := RequestFetcherFunc(func(req Request) Result {
+		res := Site{Config: c}.FetchRequest(req)
+		res.html = nil
+		return res
+	})
+	s := crawledSpider(t, ts.URL+"/docs", f, func(c *Config) { c.ExtractContent = true })
+	p := s.Pages[ts.URL+"/docs"]
+	if links := []Link{{URL: ts.URL + "/docs/intro", Text: "Intro", Position: 1}}; !reflect.DeepEqual(p.Links, links) {
+		t.Errorf("Expected links %+v, got %+v", links, p.Links)
+	}
+	if _, ok := s.Pages[ts.URL+"/docs/intro"]; !ok {
+		t.Errorf("Expected %s to be crawled", ts.URL+"/docs/intro")
+	}
+}
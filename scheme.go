@@ -0,0 +1,127 @@
+package geomi
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// The schemes of links that are commonly found on pages but can't be crawled.
+// Links with these, or any other scheme that isn't http or https, are recorded
+// but never fetched.
+const (
+	SchemeMailto     = "mailto"
+	SchemeTel        = "tel"
+	SchemeJavaScript = "javascript"
+	SchemeData       = "data"
+	SchemeFTP        = "ftp"
+)
+
+// SchemeLink is a link whose scheme isn't http or https.
+type SchemeLink struct {
+	URL    string
+	Scheme string   // lower cased, e.g. SchemeMailto
+	Pages  []string // the pages that link to it, sorted
+	Err    error    // why a mailto link isn't valid; only checked when ValidateMailto is set
+}
+
+// fetchable returns whether the url's scheme is one the spider can fetch.
+func fetchable(u *url.URL) bool {
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// addSchemeLink records the link, which isn't fetchable, and the page it was
+// found on, if any.
+func (s *Spider) addSchemeLink(u *url.URL, page string) {
+	s.Lock()
+	defer s.Unlock()
+	l, ok := s.schemeLinks[u.String()]
+	if !ok {
+		l = &SchemeLink{URL: u.String(), Scheme: u.Scheme}
+		if u.Scheme == SchemeMailto && s.Config.ValidateMailto {
+			l.Err = validMailto(u)
+		}
+		s.schemeLinks[u.String()] = l
+	}
+	if page == "" {
+		return
+	}
+	i := sort.SearchStrings(l.Pages, page)
+	if i < len(l.Pages) && l.Pages[i] == page {
+		return
+	}
+	l.Pages = append(l.Pages, "")
+	copy(l.Pages[i+1:], l.Pages[i:])
+	l.Pages[i] = page
+}
+
+// SchemeLinks returns the links with the scheme, sorted by url. If scheme is
+// empty, the links with any scheme that isn't http or https are returned.
+func (s *Spider) SchemeLinks(scheme string) []SchemeLink {
+	scheme = strings.ToLower(scheme)
+	s.Lock()
+	defer s.Unlock()
+	var links []SchemeLink
+	for _, l := range s.schemeLinks {
+		if scheme == "" || l.Scheme == scheme {
+			links = append(links, *l)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].URL < links[j].URL })
+	return links
+}
+
+// InvalidMailtoLinks returns the mailto links whose addresses aren't valid,
+// sorted by url. Addresses are only validated when ValidateMailto is set.
+func (s *Spider) InvalidMailtoLinks() []SchemeLink {
+	var invalid []SchemeLink
+	for _, l := range s.SchemeLinks(SchemeMailto) {
+		if l.Err != nil {
+			invalid = append(invalid, l)
+		}
+	}
+	return invalid
+}
+
+// validMailto returns why the mailto url's addresses aren't valid, or nil if
+// they are. The addresses are the url's path, and its to header, e.g.
+//
+//	mailto:a@example.com,b@example.com?to=c@example.com&subject=hi
+func validMailto(u *url.URL) error {
+	to := u.Opaque
+	if to == "" {
+		// mailto://a@example.com isn't a valid mailto, but it's a common mistake
+		if u.Host != "" || u.User != nil {
+			return errors.New("a mailto url doesn't have //")
+		}
+		to = u.Path
+	}
+	to, err := url.PathUnescape(to)
+	if err != nil {
+		return fmt.Errorf("%q: %w", u.Opaque, err)
+	}
+	var addrs []string
+	if to != "" {
+		addrs = strings.Split(to, ",")
+	}
+	for k, v := range u.Query() {
+		if strings.EqualFold(k, "to") {
+			for _, a := range v {
+				addrs = append(addrs, strings.Split(a, ",")...)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return errors.New("no address")
+	}
+	for _, a := range addrs {
+		a = strings.TrimSpace(a)
+		if _, err := mail.ParseAddress(a); err != nil {
+			return fmt.Errorf("%q: %w", a, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package geomi
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidMailto(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"mailto:gopher@golang.org", true},
+		{"mailto:gopher@golang.org,ken@golang.org?subject=Hello%20Gophers", true},
+		{"mailto:Gopher%20%3Cgopher@golang.org%3E", true},
+		{"mailto:?to=gopher@golang.org", true},
+		{"mailto:", false},
+		{"mailto:?subject=hi", false},
+		{"mailto:gopher", false},
+		{"mailto:gopher@golang.org,", false},
+		{"mailto:gopher@golang.org?to=ken", false},
+		{"mailto://gopher@golang.org", false},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := validMailto(u); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid to be %t, got %v", test.url, test.valid, err)
+		}
+	}
+}
+
+func TestSchemeLinks(t *testing.T) {
+	html := ResponseInfo{StatusCode: 200, ContentType: "text/html"}
+	f := statusFetcher{
+		"http://golang.org/doc/": {
+			"Docs",
+			html,
+			[]string{"http://golang.org/doc/a", "mailto:gopher@golang.org", "tel:+1-555-0100", "javascript:void(0)", "MAILTO:gopher", "ftp://ftp.golang.org/go.tgz"},
+		},
+		"http://golang.org/doc/a": {"A", html, []string{"mailto:gopher@golang.org", "data:text/plain,hi", "https://github.com/golang/go"}},
+	}
+	s := crawledSpider(t, "http://golang.org/doc/", AdaptFetcher(f), func(c *Config) { c.ValidateMailto = true })
+	// only http and https links are fetched
+	for k := range s.fetchedURLs {
+		if !strings.HasPrefix(k, "http://golang.org/") {
+			t.Errorf("Expected only the site's pages to be fetched, got %s", k)
+		}
+	}
+	if hosts := s.ExternalHosts(); !reflect.DeepEqual(hosts, []string{"github.com"}) {
+		t.Errorf("Expected only github.com to be an external host, got %v", hosts)
+	}
+	if links := s.ExternalLinks(); !reflect.DeepEqual(links, []string{"https://github.com/golang/go"}) {
+		t.Errorf("Expected only the github link to be external, got %v", links)
+	}
+	var urls []string
+	for _, l := range s.SchemeLinks("") {
+		urls = append(urls, l.URL)
+	}
+	expected := []string{"data:text/plain,hi", "ftp://ftp.golang.org/go.tgz", "javascript:void(0)", "mailto:gopher", "mailto:gopher@golang.org", "tel:+1-555-0100"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected %v, got %v", expected, urls)
+	}
+	mailto := s.SchemeLinks("MailTo")
+	if len(mailto) != 2 {
+		t.Fatalf("Expected 2 mailto links, got %v", mailto)
+	}
+	if pages := mailto[1].Pages; !reflect.DeepEqual(pages, []string{"http://golang.org/doc/", "http://golang.org/doc/a"}) {
+		t.Errorf("Expected the mailto link to be found on both pages, got %v", pages)
+	}
+	invalid := s.InvalidMailtoLinks()
+	if len(invalid) != 1 || invalid[0].URL != "mailto:gopher" {
+		t.Errorf("Expected mailto:gopher to be invalid, got %v", invalid)
+	}
+	g := s.Graph()
+	if n, ok := g.Node("tel:+1-555-0100"); !ok || n.Kind != LinkOther {
+		t.Errorf("Expected the tel link to be a node of kind %s, got %v", LinkOther, n)
+	}
+}
@@ -0,0 +1,82 @@
+package geomi
+
+import (
+	"net/http"
+	"time"
+)
+
+// throttle adapts the fetch interval to how the server is coping. The interval
+// is doubled whenever the server responds with 429 or 503, or doesn't respond in
+// time, increased by half when response times rise well above what the server
+// has shown it can do, and slowly decreased while the server is healthy. The
+// response time is the time to the first byte, so large bodies don't look like a
+// slow server. The interval always stays between min and max.
+type throttle struct {
+	interval time.Duration
+	min      time.Duration
+	max      time.Duration
+	latency  time.Duration // smoothed time to first byte
+	baseline time.Duration // the lowest smoothed response time seen
+}
+
+// newThrottle returns a throttle, bounded by the config's Min and Max fetch
+// intervals, that starts at the config's FetchInterval.
+func newThrottle(c *Config) *throttle {
+	t := &throttle{interval: c.FetchInterval, min: c.MinFetchInterval, max: c.MaxFetchInterval}
+	if t.max < t.min {
+		t.max = t.min
+	}
+	t.clamp()
+	return t
+}
+
+// observe adjusts the interval based on the result of a request.
+func (t *throttle) observe(a Attempt) {
+	if a.StatusCode == http.StatusTooManyRequests || a.StatusCode == http.StatusServiceUnavailable || errorReason(a.Err) == BrokenTimeout {
+		t.interval *= 2
+		// the server may be too busy to respond at all
+		if t.interval == 0 {
+			t.interval = time.Second
+		}
+		t.clamp()
+		return
+	}
+	// fetchers that don't know when the first byte arrived only have the
+	// duration
+	d := a.FirstByte
+	if d <= 0 {
+		d = a.Duration
+	}
+	if a.Err != nil || d <= 0 {
+		return
+	}
+	// exponentially weighted moving average, favoring history
+	if t.latency == 0 {
+		t.latency = d
+	} else {
+		t.latency = (t.latency*7 + d*3) / 10
+	}
+	if t.baseline == 0 || t.latency < t.baseline {
+		t.baseline = t.latency
+	}
+	switch {
+	case t.latency > t.baseline*2:
+		t.interval += t.interval / 2
+		if t.interval == 0 {
+			t.interval = t.latency
+		}
+	case t.latency <= t.baseline+t.baseline/5:
+		t.interval -= t.interval / 10
+	}
+	t.clamp()
+}
+
+// clamp keeps the interval between min and max.
+func (t *throttle) clamp() {
+	if t.interval < t.min {
+		t.interval = t.min
+	}
+	if t.max > 0 && t.interval > t.max {
+		t.interval = t.max
+	}
+}
@@ -0,0 +1,88 @@
+package geomi
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	c := NewConfig()
+	c.FetchInterval = time.Second
+	c.MinFetchInterval = 500 * time.Millisecond
+	c.MaxFetchInterval = 10 * time.Second
+	th := newThrottle(c)
+	healthy := Attempt{StatusCode: 200, Duration: 100 * time.Millisecond}
+	// a healthy server speeds up the crawl, but not past the min
+	th.observe(healthy)
+	if th.interval != 900*time.Millisecond {
+		t.Errorf("Expected interval to be 900ms, got %v", th.interval)
+	}
+	for i := 0; i < 50; i++ {
+		th.observe(healthy)
+	}
+	if th.interval != c.MinFetchInterval {
+		t.Errorf("Expected interval to be %v, got %v", c.MinFetchInterval, th.interval)
+	}
+	// being told to back off doubles the interval
+	th.observe(Attempt{StatusCode: 503})
+	if th.interval != time.Second {
+		t.Errorf("Expected interval to be 1s, got %v", th.interval)
+	}
+	th.observe(Attempt{StatusCode: 429})
+	if th.interval != 2*time.Second {
+		t.Errorf("Expected interval to be 2s, got %v", th.interval)
+	}
+	// rising latency slows the crawl, but not past the max
+	slow := Attempt{StatusCode: 200, Duration: 2 * time.Second}
+	for i := 0; i < 50; i++ {
+		th.observe(slow)
+	}
+	if th.interval != c.MaxFetchInterval {
+		t.Errorf("Expected interval to be %v, got %v", c.MaxFetchInterval, th.interval)
+	}
+	// errors without a response don't change anything
+	th.observe(Attempt{Err: errors.New("connection refused")})
+	if th.interval != c.MaxFetchInterval {
+		t.Errorf("Expected interval to be %v, got %v", c.MaxFetchInterval, th.interval)
+	}
+}
+
+func TestThrottleBounds(t *testing.T) {
+	c := NewConfig()
+	c.FetchInterval = time.Minute
+	c.MinFetchInterval = time.Second
+	c.MaxFetchInterval = 5 * time.Second
+	if th := newThrottle(c); th.interval != 5*time.Second {
+		t.Errorf("Expected interval to start at 5s, got %v", th.interval)
+	}
+	c.FetchInterval = 0
+	if th := newThrottle(c); th.interval != time.Second {
+		t.Errorf("Expected interval to start at 1s, got %v", th.interval)
+	}
+}
+
+func TestThrottleFirstByte(t *testing.T) {
+	c := NewConfig()
+	c.FetchInterval = time.Second
+	c.MinFetchInterval = 500 * time.Millisecond
+	c.MaxFetchInterval = 10 * time.Second
+	th := newThrottle(c)
+	for i := 0; i < 5; i++ {
+		th.observe(Attempt{StatusCode: 200, Duration: 100 * time.Millisecond, FirstByte: 50 * time.Millisecond})
+	}
+	interval := th.interval
+	// a large body takes long to download, but the server responded quickly
+	th.observe(Attempt{StatusCode: 200, Duration: 20 * time.Second, FirstByte: 50 * time.Millisecond})
+	if th.interval > interval {
+		t.Errorf("Expected a slow download not to increase the interval from %v, got %v", interval, th.interval)
+	}
+	// a timeout is the server not coping, it backs off
+	interval = th.interval
+	th.observe(Attempt{Err: &url.Error{Op: "Get", URL: "http://x/", Err: context.DeadlineExceeded}})
+	if th.interval != 2*interval {
+		t.Errorf("Expected a timeout to double the interval to %v, got %v", 2*interval, th.interval)
+	}
+}
@@ -0,0 +1,443 @@
+package geomi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The WARC record types written by a WARCWriter.
+const (
+	WARCInfo     = "warcinfo"
+	WARCRequest  = "request"
+	WARCResponse = "response"
+	WARCResource = "resource"
+	WARCMetadata = "metadata"
+	WARCRevisit  = "revisit"
+)
+
+// warcRevisitProfile is the profile of revisit records whose payload is the
+// same as an earlier response's.
+const warcRevisitProfile = "http://netpreserve.org/warc/1.1/revisit/identical-payload-digest"
+
+// WARCWriter writes the exchanges of a crawl as WARC 1.1 records, each gzipped on
+// its own, to files in a directory. Each exchange is a request record, a
+// response record, and a metadata record with the links found in the response;
+// a response whose payload has already been written is a revisit record, without
+// the payload, instead. Once a file reaches its max size, a new one is started.
+//
+// A response record has the headers and the body as they were received, before
+// the body's Content-Encoding and character set were decoded; a Site returns
+// them when it's fetching for a WARCFetcher. A fetcher that doesn't, e.g. one
+// that renders pages, has its body written as a resource record instead, as it
+// isn't what was sent over the wire.
+type WARCWriter struct {
+	Dir     string // the directory the files are written to
+	Prefix  string // the start of each file's name
+	MaxSize int64  // the size at which a file is closed and a new one is started; 0 means no limit.
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	serial  int
+	files   []string
+	seen    map[string]warcOrigin // the first response with each payload digest
+	err     error                 // the first error writing, for WARCFetcher
+}
+
+// warcOrigin is the response a revisit record refers to.
+type warcOrigin struct {
+	id   string
+	uri  string
+	date string
+}
+
+// NewWARCWriter returns a WARCWriter that writes to dir, which is created if it
+// doesn't exist. The files are named prefix-timestamp-serial.warc.gz.
+func NewWARCWriter(dir, prefix string, maxSize int64) (*WARCWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("warc: %w", err)
+	}
+	if prefix == "" {
+		prefix = "geomi"
+	}
+	return &WARCWriter{Dir: dir, Prefix: prefix, MaxSize: maxSize, seen: make(map[string]warcOrigin)}, nil
+}
+
+// Files returns the names of the files that have been written.
+func (w *WARCWriter) Files() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.files...)
+}
+
+// Close closes the current file.
+func (w *WARCWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// Write writes the records of the exchange. Fetches that got no response, e.g.
+// because of a dns error, only have a request and a metadata record, with the
+// error. A payload that isn't all of what was received is marked as truncated.
+// Each redirect that was followed, if the fetcher kept them, is written as its
+// own request and response records, before those of the final response.
+func (w *WARCWriter) Write(req Request, res Result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.rotate(); err != nil {
+		return err
+	}
+	date := time.Now().UTC().Format(time.RFC3339)
+	// the request that got the final response is the last one that was sent
+	uri, method, header := req.URL, "GET", req.Header
+	hops := res.exchanges
+	var last *wireExchange
+	if n := len(hops); n > 0 {
+		hops, last = hops[:n-1], hops[n-1]
+		uri, method, header = last.url, last.method, last.header
+	}
+	for _, e := range hops {
+		if err := w.redirect(e, date); err != nil {
+			return err
+		}
+	}
+	reqID := warcRecordID()
+	respID := warcRecordID()
+	hdr := warcHeader{{"WARC-Target-URI", uri}, {"WARC-Date", date}}
+	block, err := warcRequestBlock(method, uri, header)
+	if err != nil {
+		return err
+	}
+	var concurrent string
+	switch {
+	case last != nil && last.response != nil:
+		concurrent = respID
+		var truncated string
+		switch {
+		case res.Truncated:
+			truncated = "length"
+		case res.Err != nil:
+			// the body couldn't be read, or decoded, so not all of it may have been
+			truncated = "unspecified"
+		}
+		if err := w.response(respID, reqID, uri, date, last.response, last.body.Bytes(), truncated); err != nil {
+			return err
+		}
+	case res.StatusCode > 0:
+		concurrent = respID
+		rh := append(warcHeader{{"WARC-Type", WARCResource}, {"WARC-Record-ID", respID}}, hdr...)
+		ct := res.ContentType
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		rh = append(rh, warcField{"WARC-Concurrent-To", reqID}, warcField{"Content-Type", ct})
+		switch {
+		case res.Truncated:
+			rh = append(rh, warcField{"WARC-Truncated", "length"})
+		case res.Err != nil || (res.Body == "" && res.ContentLength != 0):
+			// the body wasn't kept, or not all of it was
+			rh = append(rh, warcField{"WARC-Truncated", "unspecified"})
+		}
+		if err := w.record(rh, []byte(res.Body)); err != nil {
+			return err
+		}
+	}
+	if err := w.request(reqID, concurrent, uri, date, block); err != nil {
+		return err
+	}
+	mh := append(warcHeader{{"WARC-Type", WARCMetadata}, {"WARC-Record-ID", warcRecordID()}}, hdr...)
+	if concurrent != "" {
+		mh = append(mh, warcField{"WARC-Refers-To", concurrent})
+	} else {
+		mh = append(mh, warcField{"WARC-Concurrent-To", reqID})
+	}
+	mh = append(mh, warcField{"Content-Type", "application/warc-fields"})
+	return w.record(mh, warcMetadataBlock(req, res))
+}
+
+// redirect writes the request and response records of a redirect that was
+// followed.
+func (w *WARCWriter) redirect(e *wireExchange, date string) error {
+	reqID := warcRecordID()
+	respID := warcRecordID()
+	block, err := warcRequestBlock(e.method, e.url, e.header)
+	if err != nil {
+		return err
+	}
+	// the client only reads some of a redirect's body
+	var truncated string
+	if int64(e.body.Len()) < e.response.ContentLength {
+		truncated = "length"
+	}
+	if err := w.response(respID, reqID, e.url, date, e.response, e.body.Bytes(), truncated); err != nil {
+		return err
+	}
+	return w.request(reqID, respID, e.url, date, block)
+}
+
+// response writes a response record, with the status line and headers, and the
+// payload, as they were received. If the payload has already been written, and
+// all of it was received, a revisit record, without the payload, is written
+// instead. truncated is the WARC-Truncated reason, if the payload isn't all of
+// what was sent.
+func (w *WARCWriter) response(id, concurrent, uri, date string, resp *http.Response, payload []byte, truncated string) error {
+	head := warcResponseHead(resp)
+	digest := warcDigest(payload)
+	rh := warcHeader{{"WARC-Record-ID", id}, {"WARC-Target-URI", uri}, {"WARC-Date", date}}
+	rh = append(rh, warcField{"WARC-Concurrent-To", concurrent}, warcField{"Content-Type", "application/http;msgtype=response"})
+	o, revisit := w.seen[digest]
+	if revisit && len(payload) > 0 && truncated == "" {
+		rh = append(warcHeader{{"WARC-Type", WARCRevisit}}, rh...)
+		rh = append(rh, warcField{"WARC-Payload-Digest", digest}, warcField{"WARC-Profile", warcRevisitProfile}, warcField{"WARC-Refers-To", o.id}, warcField{"WARC-Refers-To-Target-URI", o.uri}, warcField{"WARC-Refers-To-Date", o.date})
+		return w.record(rh, head)
+	}
+	if len(payload) > 0 {
+		w.seen[digest] = warcOrigin{id, uri, date}
+	}
+	rh = append(warcHeader{{"WARC-Type", WARCResponse}}, rh...)
+	rh = append(rh, warcField{"WARC-Payload-Digest", digest})
+	if truncated != "" {
+		rh = append(rh, warcField{"WARC-Truncated", truncated})
+	}
+	return w.record(rh, append(head, payload...))
+}
+
+// request writes a request record; concurrent is the id of its response's
+// record, if it got one.
+func (w *WARCWriter) request(id, concurrent, uri, date string, block []byte) error {
+	qh := warcHeader{{"WARC-Type", WARCRequest}, {"WARC-Record-ID", id}, {"WARC-Target-URI", uri}, {"WARC-Date", date}}
+	if concurrent != "" {
+		qh = append(qh, warcField{"WARC-Concurrent-To", concurrent})
+	}
+	qh = append(qh, warcField{"Content-Type", "application/http;msgtype=request"})
+	return w.record(qh, block)
+}
+
+// rotate starts a new file if there isn't one, or if the current one is full.
+func (w *WARCWriter) rotate() error {
+	if w.f != nil && (w.MaxSize <= 0 || w.size < w.MaxSize) {
+		return nil
+	}
+	if w.f != nil {
+		if err := w.f.Close(); err != nil {
+			return fmt.Errorf("warc: %w", err)
+		}
+		w.f = nil
+	}
+	name := fmt.Sprintf("%s-%s-%05d.warc.gz", w.Prefix, time.Now().UTC().Format("20060102150405"), w.serial)
+	w.serial++
+	f, err := os.Create(filepath.Join(w.Dir, name))
+	if err != nil {
+		return fmt.Errorf("warc: %w", err)
+	}
+	w.f, w.size = f, 0
+	w.files = append(w.files, f.Name())
+	info := "software: geomi\r\nformat: WARC File Format 1.1\r\nconformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n"
+	h := warcHeader{
+		{"WARC-Type", WARCInfo},
+		{"WARC-Record-ID", warcRecordID()},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339)},
+		{"WARC-Filename", name},
+		{"Content-Type", "application/warc-fields"},
+	}
+	return w.record(h, []byte(info))
+}
+
+// record writes the record, as its own gzip member, to the current file.
+func (w *WARCWriter) record(h warcHeader, block []byte) error {
+	var b bytes.Buffer
+	b.WriteString("WARC/1.1\r\n")
+	for _, f := range h {
+		fmt.Fprintf(&b, "%s: %s\r\n", f.name, f.value)
+	}
+	fmt.Fprintf(&b, "WARC-Block-Digest: %s\r\n", warcDigest(block))
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(block))
+	b.Write(block)
+	b.WriteString("\r\n\r\n")
+	cw := &countingWriter{w: w.f}
+	gz := gzip.NewWriter(cw)
+	if _, err := gz.Write(b.Bytes()); err != nil {
+		return fmt.Errorf("warc: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("warc: %w", err)
+	}
+	w.size += cw.n
+	return nil
+}
+
+// warcField is a named field of a WARC record header.
+type warcField struct {
+	name  string
+	value string
+}
+
+// warcHeader is a WARC record header; the fields are written in order.
+type warcHeader []warcField
+
+// warcRequestBlock returns the http request, with the headers that were sent.
+func warcRequestBlock(method, uri string, h http.Header) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("warc: %w", err)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: %s\r\n", method, u.RequestURI(), u.Host)
+	h.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes(), nil
+}
+
+// warcResponseHead returns the response's status line and headers, as they were
+// received. A chunked body was dechunked when it was read, so the payload is
+// what follows the headers.
+func warcResponseHead(resp *http.Response) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// wireExchange is a request that was sent, and the response it got, as they were
+// on the wire, for a WARCWriter.
+type wireExchange struct {
+	method   string
+	url      string
+	header   http.Header    // the headers that were sent
+	response *http.Response // the status line and headers; nil if there was no response
+	body     bytes.Buffer   // the response's body, as it was received, as much of it as was read
+}
+
+// wireTransport is a RoundTripper that keeps every exchange it makes, including
+// those of the redirects a client follows.
+type wireTransport struct {
+	rt        http.RoundTripper // if nil, http.DefaultTransport is used
+	exchanges []*wireExchange
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *wireTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	e := &wireExchange{method: req.Method, url: req.URL.String(), header: req.Header.Clone()}
+	if e.header == nil {
+		e.header = make(http.Header)
+	}
+	t.exchanges = append(t.exchanges, e)
+	rt := t.rt
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	resp, err := rt.RoundTrip(req)
+	// without a User-Agent, the transport sends its own
+	if _, ok := req.Header["User-Agent"]; !ok {
+		e.header.Set("User-Agent", "Go-http-client/1.1")
+		if resp != nil && resp.ProtoMajor == 2 {
+			e.header.Set("User-Agent", "Go-http-client/2.0")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.response = resp
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(resp.Body, &e.body), resp.Body}
+	return resp, nil
+}
+
+// warcMetadataBlock returns the exchange's metadata: where the url was found,
+// how long the fetch took, any error, and the links found, as warc-fields.
+func warcMetadataBlock(req Request, res Result) []byte {
+	var b bytes.Buffer
+	if req.Referrer != "" {
+		fmt.Fprintf(&b, "via: %s\r\n", req.Referrer)
+	}
+	fmt.Fprintf(&b, "hopsFromSeed: %d\r\n", req.Depth)
+	fmt.Fprintf(&b, "fetchTimeMs: %d\r\n", res.Duration.Milliseconds())
+	if res.Err != nil {
+		fmt.Fprintf(&b, "fetchError: %s\r\n", strings.ReplaceAll(res.Err.Error(), "\n", " "))
+	}
+	urls := append([]string(nil), res.URLs...)
+	sort.Strings(urls)
+	for _, u := range urls {
+		fmt.Fprintf(&b, "outlink: %s\r\n", u)
+	}
+	return b.Bytes()
+}
+
+// warcDigest returns the base32 sha1 digest of b, in WARC form.
+func warcDigest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// warcRecordID returns a new, random, record id.
+func warcRecordID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40 // version 4
+	u[8] = u[8]&0x3f | 0x80 // variant 10
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// WARCFetcher is a RequestFetcher that writes every exchange of its fetcher with
+// the WARCWriter. Errors writing the records don't fail the fetch; the first
+// one is returned by Err.
+type WARCFetcher struct {
+	Fetcher RequestFetcher
+	Writer  *WARCWriter
+}
+
+// FetchRequest implements RequestFetcher.
+func (f WARCFetcher) FetchRequest(req Request) Result {
+	// the fetcher returns the bytes it received, if it can
+	req.wire = true
+	start := time.Now()
+	res := f.Fetcher.FetchRequest(req)
+	if res.Duration == 0 {
+		res.Duration = time.Since(start)
+	}
+	if err := f.Writer.Write(req, res); err != nil {
+		f.Writer.mu.Lock()
+		if f.Writer.err == nil {
+			f.Writer.err = err
+		}
+		f.Writer.mu.Unlock()
+	}
+	return res
+}
+
+// Err returns the first error writing the records, if there was one.
+func (f WARCFetcher) Err() error {
+	f.Writer.mu.Lock()
+	defer f.Writer.mu.Unlock()
+	return f.Writer.err
+}
+
+// WARCFiles returns the names of the WARC files written by the crawl, if
+// Config.WARCDir was set.
+func (s *Spider) WARCFiles() []string {
+	if s.warc == nil {
+		return nil
+	}
+	return s.warc.Files()
+}
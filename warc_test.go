@@ -0,0 +1,252 @@
+package geomi
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// warcRecord is a record read back from a WARC file.
+type warcRecord struct {
+	header http.Header
+	block  string
+}
+
+// readWARC returns the records in the file, checking that each is its own gzip
+// member.
+func readWARC(t *testing.T, name string) []warcRecord {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var recs []warcRecord
+	for {
+		gz, err := gzip.NewReader(br)
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz.Multistream(false)
+		b, err := io.ReadAll(gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := string(b)
+		if !strings.HasPrefix(s, "WARC/1.1\r\n") || !strings.HasSuffix(s, "\r\n\r\n") {
+			t.Fatalf("%s: invalid record: %q", name, s)
+		}
+		i := strings.Index(s, "\r\n\r\n")
+		h := make(http.Header)
+		for _, l := range strings.Split(s[len("WARC/1.1\r\n"):i], "\r\n") {
+			kv := strings.SplitN(l, ": ", 2)
+			h.Add(kv[0], kv[1])
+		}
+		block := s[i+4 : len(s)-4]
+		if n, _ := strconv.Atoi(h.Get("Content-Length")); n != len(block) {
+			t.Errorf("%s: expected the Content-Length to be %d, got %d", name, len(block), n)
+		}
+		if d := h.Get("WARC-Block-Digest"); d != warcDigest([]byte(block)) {
+			t.Errorf("%s: the block digest %s doesn't match", name, d)
+		}
+		recs = append(recs, warcRecord{h, block})
+	}
+}
+
+func TestWARC(t *testing.T) {
+	page := compress(t, "gzip", "<p>Docs</p>")
+	png := []byte{0x89, 'P', 'N', 'G', 0xff, 0x00}
+	big := strings.Repeat("a", 2048)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/doc/":
+			w.Header().Set("Content-Type", "text/html; charset=shift_jis")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(page)
+		case "/doc/a", "/doc/b":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "same")
+		case "/doc/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		case "/doc/big":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, big)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	doc := ts.URL + "/doc/"
+	links := map[string][]string{doc: {doc + "b", doc + "a", doc + "logo.png", doc + "big", doc + "rendered", doc + "gone"}}
+	c := NewConfig()
+	c.DownloadNonHTML = false
+	c.MaxBodySize = 1024
+	site := Site{Config: c}
+	f := RequestFetcherFunc(func(req Request) Result {
+		switch req.URL {
+		case doc + "rendered":
+			// a fetcher that doesn't return what it received
+			return Result{Body: "<p>Rendered</p>", ResponseInfo: ResponseInfo{Status: "200 OK", StatusCode: 200, ContentType: "text/html"}}
+		case doc + "gone":
+			return Result{ResponseInfo: ResponseInfo{Err: errors.New("no such host")}}
+		}
+		res := site.FetchRequest(req)
+		res.URLs = links[req.URL]
+		return res
+	})
+	dir := t.TempDir()
+	s := crawledSpider(t, doc, f, func(c *Config) {
+		c.WARCDir = dir
+		// every exchange after the first goes in a new file
+		c.WARCMaxSize = 1
+		c.Retry = RetryPolicy{MaxAttempts: 1}
+	})
+	files := s.WARCFiles()
+	if len(files) != 7 {
+		t.Fatalf("Expected 7 files, got %v", files)
+	}
+	// the records of each url, by type
+	recs := make(map[string]map[string]warcRecord)
+	for _, name := range files {
+		for i, r := range readWARC(t, name) {
+			typ := r.header.Get("WARC-Type")
+			if i == 0 {
+				if typ != WARCInfo {
+					t.Errorf("%s: expected a warcinfo record first, got %s", name, typ)
+				}
+				continue
+			}
+			u := r.header.Get("WARC-Target-URI")
+			if recs[u] == nil {
+				recs[u] = make(map[string]warcRecord)
+			}
+			recs[u][typ] = r
+		}
+	}
+	// the response is what was received: the headers and the gzipped body
+	resp, ok := recs[doc][WARCResponse]
+	if !ok {
+		t.Fatalf("Expected a response record for %s, got %v", doc, recs[doc])
+	}
+	if !strings.HasPrefix(resp.block, "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(resp.block, "\r\n\r\n"+string(page)) {
+		t.Errorf("Expected the http response as received, got %q", resp.block)
+	}
+	if !strings.Contains(resp.block, "Content-Encoding: gzip\r\n") || !strings.Contains(resp.block, "Content-Type: text/html; charset=shift_jis\r\n") {
+		t.Errorf("Expected the headers as received, got %q", resp.block)
+	}
+	if d := resp.header.Get("WARC-Payload-Digest"); d != warcDigest(page) {
+		t.Errorf("Expected the payload digest to be of the bytes received, %s, got %s", warcDigest(page), d)
+	}
+	if req := recs[doc][WARCRequest]; req.header.Get("WARC-Concurrent-To") != resp.header.Get("WARC-Record-ID") || !strings.HasPrefix(req.block, "GET /doc/ HTTP/1.1\r\nHost: "+strings.TrimPrefix(ts.URL, "http://")+"\r\n") {
+		t.Errorf("Expected the request to be concurrent to the response, got %v %q", req.header, req.block)
+	}
+	if meta := recs[doc][WARCMetadata]; !strings.HasPrefix(meta.block, "hopsFromSeed: 0\r\n") || !strings.HasSuffix(meta.block, "outlink: "+doc+"rendered\r\n") {
+		t.Errorf("Unexpected metadata: %q", meta.block)
+	}
+	// a and b have the same payload; a, which was fetched second, is a revisit
+	revisit, ok := recs[doc+"a"][WARCRevisit]
+	if !ok {
+		t.Fatalf("Expected a revisit record for a, got %v", recs[doc+"a"])
+	}
+	if revisit.header.Get("WARC-Refers-To") != recs[doc+"b"][WARCResponse].header.Get("WARC-Record-ID") || revisit.header.Get("WARC-Refers-To-Target-URI") != doc+"b" || strings.HasSuffix(revisit.block, "same") {
+		t.Errorf("Expected a revisit of b, without the payload, got %v %q", revisit.header, revisit.block)
+	}
+	// the png's body isn't kept, but all of it is written
+	if r := recs[doc+"logo.png"][WARCResponse]; !strings.HasSuffix(r.block, "\r\n\r\n"+string(png)) || r.header.Get("WARC-Truncated") != "" {
+		t.Errorf("Expected all of the png, got %v %q", r.header, r.block)
+	}
+	if r := recs[doc+"big"][WARCResponse]; r.header.Get("WARC-Truncated") != "length" {
+		t.Errorf("Expected the body that was too big to be truncated, got %v", r.header)
+	}
+	// what wasn't received over the wire is a resource
+	if r, ok := recs[doc+"rendered"][WARCResource]; !ok || r.block != "<p>Rendered</p>" || r.header.Get("Content-Type") != "text/html" {
+		t.Errorf("Expected a resource record of the rendered page, got %v", recs[doc+"rendered"])
+	}
+	gone := recs[doc+"gone"]
+	if _, ok := gone[WARCResponse]; ok || len(gone) != 2 {
+		t.Errorf("Expected only a request and a metadata record for an error, got %v", gone)
+	}
+	if meta := gone[WARCMetadata]; !strings.Contains(meta.block, "via: "+doc+"\r\nhopsFromSeed: 1\r\n") || !strings.Contains(meta.block, "fetchError: no such host\r\n") {
+		t.Errorf("Expected the error's metadata, got %q", meta.block)
+	}
+}
+
+func TestWARCRedirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+		io.WriteString(w, "new")
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	w, err := NewWARCWriter(dir, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := WARCFetcher{Fetcher: Site{Config: NewConfig()}, Writer: w}
+	req := Request{URL: ts.URL + "/old", Header: http.Header{"User-Agent": {"geomi"}, "Referer": {ts.URL + "/"}}}
+	if res := f.FetchRequest(req); res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected a 200, got %+v", res.ResponseInfo)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	type record struct {
+		typ, uri string
+	}
+	var order []record
+	recs := make(map[record]warcRecord)
+	for _, r := range readWARC(t, w.Files()[0])[1:] {
+		k := record{r.header.Get("WARC-Type"), r.header.Get("WARC-Target-URI")}
+		order = append(order, k)
+		recs[k] = r
+	}
+	// each hop is its own exchange, with its own target uri
+	expected := []record{
+		{WARCResponse, ts.URL + "/old"},
+		{WARCRequest, ts.URL + "/old"},
+		{WARCResponse, ts.URL + "/new"},
+		{WARCRequest, ts.URL + "/new"},
+		{WARCMetadata, ts.URL + "/new"},
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("Expected the records %v, got %v", expected, order)
+	}
+	if r := recs[record{WARCResponse, ts.URL + "/old"}]; !strings.HasPrefix(r.block, "HTTP/1.1 301 Moved Permanently\r\n") || !strings.Contains(r.block, "Location: /new\r\n") {
+		t.Errorf("Expected the redirect as received, got %q", r.block)
+	}
+	if r := recs[record{WARCResponse, ts.URL + "/new"}]; !strings.HasPrefix(r.block, "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(r.block, "\r\n\r\nnew") {
+		t.Errorf("Expected the final response as received, got %q", r.block)
+	}
+	// the requests have the headers that were sent, including the ones the Site
+	// and the client add
+	tests := []struct {
+		uri  string
+		path string
+	}{
+		{ts.URL + "/old", "/old"},
+		{ts.URL + "/new", "/new"},
+	}
+	for _, test := range tests {
+		r := recs[record{WARCRequest, test.uri}]
+		for _, l := range []string{"GET " + test.path + " HTTP/1.1\r\n", "Accept-Encoding: " + AcceptEncoding + "\r\n", "User-Agent: geomi\r\n", "Referer: " + ts.URL + "/\r\n"} {
+			if !strings.Contains(r.block, l) {
+				t.Errorf("%s: expected the request to have %q, got %q", test.uri, l, r.block)
+			}
+		}
+	}
+}